@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: key-service config print [-file path]")
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	file := fs.String("file", "", "config file to layer under env, overrides "+config.ConfigFileEnv)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		if err := os.Setenv(config.ConfigFileEnv, *file); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Build()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg.Redacted())
+}
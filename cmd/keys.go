@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/retro-board/key-service/internal/audit"
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+	pb "github.com/retro-board/protos/generated/key/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const keysUsage = `usage: key-service keys <command> [flags] <user-id> [flags]
+
+commands:
+  get       show a user's key set, only hashes are stored so the keys themselves can't be shown
+  create    issue a new key set for a user, replacing the old one, and print the keys
+  revoke    revoke a user's keys for -service, or every service when it isn't given
+  list      list every user's key set, takes no user-id
+
+get and create go through the running service with -addr, otherwise they use the key store directly`
+
+// keyRow is one service's key in a key set, without the hash
+type keyRow struct {
+	Service   string    `json:"service"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+	Previous  bool      `json:"previous"`
+}
+
+type keySet struct {
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired"`
+	Revoked   []string  `json:"revoked,omitempty"`
+	Keys      []keyRow  `json:"keys,omitempty"`
+}
+
+type createdKeys struct {
+	UserID string            `json:"user_id"`
+	Keys   map[string]string `json:"keys"`
+}
+
+type keysFlags struct {
+	fs         *flag.FlagSet
+	json       *bool
+	addr       *string
+	serviceKey *string
+	service    *string
+}
+
+func newKeysFlags(name string) keysFlags {
+	fs := flag.NewFlagSet("keys "+name, flag.ContinueOnError)
+	return keysFlags{
+		fs:         fs,
+		json:       fs.Bool("json", false, "print json rather than a table"),
+		addr:       fs.String("addr", "", "grpc address of a running key-service, get and create only"),
+		serviceKey: fs.String("service-key", os.Getenv("KEY_SERVICE_KEY"), "service key to call -addr with"),
+		service:    fs.String("service", "", "the service to revoke, revoke only"),
+	}
+}
+
+// userID parses the flags and returns the one user-id argument, flags can come before or after it
+func (f keysFlags) userID(args []string) (string, error) {
+	var positional []string
+	for {
+		if err := f.fs.Parse(args); err != nil {
+			return "", err
+		}
+		if f.fs.NArg() == 0 {
+			break
+		}
+		// flag stops at the first argument that isn't a flag, so carry on parsing after it
+		positional = append(positional, f.fs.Arg(0))
+		args = f.fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		return "", fmt.Errorf("%s needs one user-id", f.fs.Name())
+	}
+
+	return positional[0], nil
+}
+
+func (f keysFlags) output() output {
+	return output{w: stdout, json: *f.json}
+}
+
+func keysCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	f := newKeysFlags(args[0])
+	switch args[0] {
+	case "get":
+		return keysGet(ctx, f, args[1:])
+	case "create":
+		return keysCreate(ctx, f, args[1:])
+	case "revoke":
+		return keysRevoke(ctx, f, args[1:])
+	case "list":
+		return keysList(ctx, f, args[1:])
+	}
+
+	return fmt.Errorf("unknown keys command: %s\n%s", args[0], keysUsage)
+}
+
+func keysGet(ctx context.Context, f keysFlags, args []string) error {
+	userID, err := f.userID(args)
+	if err != nil {
+		return err
+	}
+
+	if *f.addr != "" {
+		res, err := callKeyService(ctx, f, func(ctx context.Context, c pb.KeyServiceClient) (*pb.KeyResponse, error) {
+			return c.Get(ctx, &pb.GetRequest{UserId: userID, ServiceKey: *f.serviceKey})
+		})
+		if err != nil {
+			return err
+		}
+		return f.output().print(struct {
+			UserID string `json:"user_id"`
+			Status string `json:"status"`
+		}{userID, res.Status}, table{
+			header: []string{"USER_ID", "STATUS"},
+			rows:   [][]string{{userID, res.Status}},
+		})
+	}
+
+	_, store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore(ctx, store)
+
+	data, err := store.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return key.ErrUserNotFound
+	}
+
+	set := newKeySet(*data)
+	t := table{header: []string{"SERVICE", "EXPIRES_AT", "REVOKED", "PREVIOUS"}}
+	for _, row := range set.Keys {
+		t.rows = append(t.rows, []string{row.Service, formatTime(row.ExpiresAt), fmt.Sprint(row.Revoked), fmt.Sprint(row.Previous)})
+	}
+
+	return f.output().print(set, t)
+}
+
+func keysCreate(ctx context.Context, f keysFlags, args []string) error {
+	userID, err := f.userID(args)
+	if err != nil {
+		return err
+	}
+
+	created := createdKeys{UserID: userID}
+	if *f.addr != "" {
+		res, err := callKeyService(ctx, f, func(ctx context.Context, c pb.KeyServiceClient) (*pb.KeyResponse, error) {
+			return c.Create(ctx, &pb.CreateRequest{UserId: userID, ServiceKey: *f.serviceKey})
+		})
+		if err != nil {
+			return err
+		}
+		created.Keys = map[string]string{
+			key.ServiceUser:        res.User,
+			key.ServiceRetro:       res.Retro,
+			key.ServiceTimer:       res.Timer,
+			key.ServiceCompany:     res.Company,
+			key.ServiceBilling:     res.Billing,
+			key.ServicePermissions: res.Permissions,
+		}
+	} else {
+		cfg, store, err := openStore(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStore(ctx, store)
+
+		k, err := newAuditedKey(ctx, cfg, store)
+		if err != nil {
+			return err
+		}
+		defer closeAudit(ctx, k)
+
+		keys, createErr := k.CreateKeys(ctx, userID)
+		recordCLI(k, key.OperationCreate, userID, "", createErr)
+		if createErr != nil {
+			return createErr
+		}
+		created.Keys = keys.Keys
+	}
+
+	t := table{header: []string{"SERVICE", "KEY"}}
+	for _, service := range sortedKeys(created.Keys) {
+		t.rows = append(t.rows, []string{service, created.Keys[service]})
+	}
+
+	return f.output().print(created, t)
+}
+
+func keysRevoke(ctx context.Context, f keysFlags, args []string) error {
+	userID, err := f.userID(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore(ctx, store)
+
+	k, err := newAuditedKey(ctx, cfg, store)
+	if err != nil {
+		return err
+	}
+	defer closeAudit(ctx, k)
+
+	services := cfg.Local.Services.Names()
+	if *f.service != "" {
+		if !k.ValidService(*f.service) {
+			return key.ErrUnknownService
+		}
+		services = []string{*f.service}
+	}
+
+	revokeErr := k.RevokeKeys(ctx, userID, services)
+	recordCLI(k, key.OperationRevoke, userID, *f.service, revokeErr)
+	if revokeErr != nil {
+		return revokeErr
+	}
+
+	t := table{header: []string{"USER_ID", "REVOKED"}}
+	for _, service := range services {
+		t.rows = append(t.rows, []string{userID, service})
+	}
+
+	return f.output().print(struct {
+		UserID  string   `json:"user_id"`
+		Revoked []string `json:"revoked"`
+	}{userID, services}, t)
+}
+
+func keysList(ctx context.Context, f keysFlags, args []string) error {
+	if err := f.fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore(ctx, store)
+
+	data, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	sets := make([]keySet, 0, len(data))
+	t := table{header: []string{"USER_ID", "EXPIRES_AT", "EXPIRED", "SERVICES", "REVOKED"}}
+	for _, d := range data {
+		set := newKeySet(d)
+		set.Keys = nil
+		sets = append(sets, set)
+		t.rows = append(t.rows, []string{
+			set.UserID,
+			formatTime(set.ExpiresAt),
+			fmt.Sprint(set.Expired),
+			strings.Join(d.Keys.Services(), ","),
+			strings.Join(set.Revoked, ","),
+		})
+	}
+
+	return f.output().print(sets, t)
+}
+
+// newAuditedKey records to the same audit sink as the service, so changes made from here are in the trail too
+func newAuditedKey(ctx context.Context, cfg *config.Config, store key.KeyStore) (*key.Key, error) {
+	sink, err := key.NewAuditSink(ctx, cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("audit sink: %w", err)
+	}
+
+	k := key.NewKey(cfg, store)
+	k.Audit = sink
+	return k, nil
+}
+
+// closeAudit waits for the queued events, it has to run before the store is closed
+func closeAudit(ctx context.Context, k *key.Key) {
+	if err := audit.Close(ctx, k.Audit); err != nil {
+		fmt.Fprintf(os.Stderr, "close audit sink: %v\n", err)
+	}
+}
+
+func recordCLI(k *key.Key, operation, userID, service string, keyErr *key.Error) {
+	event := audit.Event{
+		Transport: key.TransportCLI,
+		Caller:    os.Getenv("USER"),
+		Operation: operation,
+		UserID:    userID,
+		Service:   service,
+		Outcome:   audit.OutcomeSuccess,
+	}
+	if keyErr != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = keyErr.Reason
+	}
+
+	k.Record(event)
+}
+
+func newKeySet(d key.DataSet) keySet {
+	set := keySet{
+		UserID:    d.UserID,
+		ExpiresAt: d.Expires(),
+		Expired:   d.Expired(),
+		Revoked:   d.Revoked,
+	}
+	for _, serviceKey := range d.ServiceKeys() {
+		set.Keys = append(set.Keys, keyRow{
+			Service:   serviceKey.Service,
+			ExpiresAt: serviceKey.ExpiresAt,
+			Revoked:   serviceKey.Revoked,
+			Previous:  serviceKey.Previous,
+		})
+	}
+
+	return set
+}
+
+// callKeyService makes one call to a running key-service, a failure in the legacy Status field comes back as an error
+func callKeyService(ctx context.Context, f keysFlags, call func(context.Context, pb.KeyServiceClient) (*pb.KeyResponse, error)) (*pb.KeyResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, *f.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	res, err := call(ctx, pb.NewKeyServiceClient(conn))
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != "" && res.Status != "ok" {
+		return nil, fmt.Errorf("key-service: %s", res.Status)
+	}
+
+	return res, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/audit"
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+)
+
+// useMemoryStore has every command in the test share one memory store, and captures what they print
+func useMemoryStore(t *testing.T) (key.KeyStore, *bytes.Buffer) {
+	t.Helper()
+
+	cfg := &config.Config{
+		Local: config.Local{
+			KeyStore:  key.StoreMemory,
+			KeyPepper: "pepper",
+			KeyTTL:    time.Hour,
+			Services:  config.DefaultServices(),
+			Audit: config.Audit{
+				Sink: audit.SinkMemory,
+			},
+		},
+	}
+	store := key.NewMemory()
+
+	var out bytes.Buffer
+	oldOpen, oldStdout := openStore, stdout
+	openStore = func(ctx context.Context) (*config.Config, key.KeyStore, error) {
+		return cfg, store, nil
+	}
+	stdout = &out
+	t.Cleanup(func() {
+		openStore, stdout = oldOpen, oldStdout
+	})
+
+	return store, &out
+}
+
+func TestKeysCommand(t *testing.T) {
+	store, out := useMemoryStore(t)
+
+	// each step runs against the store the steps before it left behind
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr error
+		errText string
+	}{
+		{
+			name: "test_create",
+			args: []string{"create", "tester"},
+			want: []string{"SERVICE", key.ServiceTimer, key.ServiceBilling},
+		},
+		{
+			name: "test_get",
+			args: []string{"get", "-json", "tester"},
+			want: []string{`"user_id": "tester"`, `"service": "timer"`},
+		},
+		{
+			name: "test_revoke_flag_after_user",
+			args: []string{"revoke", "tester", "-service", key.ServiceTimer},
+			want: []string{"tester", key.ServiceTimer},
+		},
+		{
+			name:    "test_revoke_unknown_service",
+			args:    []string{"revoke", "-service", "bob", "tester"},
+			wantErr: key.ErrUnknownService,
+		},
+		{
+			name: "test_list",
+			args: []string{"list"},
+			want: []string{"USER_ID", "tester"},
+		},
+		{
+			name:    "test_get_missing_user",
+			args:    []string{"get", "bob"},
+			wantErr: key.ErrUserNotFound,
+		},
+		{
+			name:    "test_two_users",
+			args:    []string{"get", "tester", "bob"},
+			errText: "needs one user-id",
+		},
+		{
+			name:    "test_unknown_command",
+			args:    []string{"bogus"},
+			errText: "unknown keys command",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			err := keysCommand(context.Background(), tt.args)
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("keysCommand() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("keysCommand() error = %v, want %q", err, tt.errText)
+				}
+			case err != nil:
+				t.Fatalf("keysCommand() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("keysCommand() printed %q, want it to contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+
+	data, err := store.Get(context.Background(), "tester")
+	if err != nil || data == nil {
+		t.Fatalf("Get() = %v, %v, want the tester key set", data, err)
+	}
+	if !reflect.DeepEqual(data.Revoked, []string{key.ServiceTimer}) {
+		t.Errorf("Revoked = %v, want only timer revoked", data.Revoked)
+	}
+}
+
+func TestConnectStore_Memory(t *testing.T) {
+	cfg := &config.Config{
+		Local: config.Local{
+			KeyStore: key.StoreMemory,
+		},
+	}
+
+	if _, err := connectStore(context.Background(), cfg); !errors.Is(err, errMemoryStore) {
+		t.Errorf("connectStore() error = %v, want %v", err, errMemoryStore)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// stdout is where results are printed, tests swap it for a buffer
+var stdout io.Writer = os.Stdout
+
+// output prints a result as a table, or as json for scripts when -json is given
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (o output) print(v interface{}, t table) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(t.header, "\t")); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutput_Print(t *testing.T) {
+	v := struct {
+		UserID string `json:"user_id"`
+	}{"tester"}
+	tbl := table{
+		header: []string{"USER_ID", "SERVICE"},
+		rows:   [][]string{{"tester", "user"}},
+	}
+
+	tests := []struct {
+		name string
+		json bool
+		want string
+	}{
+		{
+			name: "test_table",
+			want: "USER_ID  SERVICE\ntester   user\n",
+		},
+		{
+			name: "test_json",
+			json: true,
+			want: "{\n  \"user_id\": \"tester\"\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (output{w: &buf, json: tt.json}).print(v, tbl); err != nil {
+				t.Fatalf("print: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got: %q, want: %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
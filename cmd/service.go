@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/retro-board/key-service/internal/config"
@@ -14,22 +19,71 @@ var (
 	ServiceName  = "base-service"
 )
 
+const usage = `usage: key-service [command]
+
+commands:
+  serve           run the service, the default when no command is given
+  config print    print the resolved config with secrets redacted
+  keys get        show a user's key set
+  keys create     issue a new key set for a user
+  keys revoke     revoke a user's keys
+  keys list       list every user's key set
+  store migrate   bring key sets written by older versions up to date
+
+run a command with -h for its flags
+`
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		_ = bugLog.Errorf("%s: %v", ServiceName, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return serve()
+	}
+
+	switch args[0] {
+	case "serve":
+		return serve()
+	case "config":
+		return configCommand(args[1:])
+	case "keys":
+		return keysCommand(context.Background(), args[1:])
+	case "store":
+		return storeCommand(context.Background(), args[1:])
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func serve() error {
 	bugLog.Local().Info(fmt.Sprintf("Starting %s", ServiceName))
 	bugLog.Local().Info(fmt.Sprintf("Version: %s, Hash: %s", BuildVersion, BuildHash))
 
 	cfg, err := config.Build()
 	if err != nil {
-		_ = bugLog.Errorf("config: %v", err)
-		return
+		return fmt.Errorf("config: %w", err)
 	}
 
 	s := &service.Service{
 		Config: cfg,
 	}
 
-	if err := s.Start(); err != nil {
-		_ = bugLog.Errorf("start service: %v", err)
-		return
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := s.Start(ctx); err != nil {
+		return fmt.Errorf("start service: %w", err)
 	}
+
+	return nil
 }
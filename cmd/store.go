@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+)
+
+// errMemoryStore is returned when KEY_STORE is memory, the cli would get an empty store of its own that
+// is gone when the command ends, so changes would look like they worked while doing nothing
+var errMemoryStore = errors.New("key store: the memory store only lives inside the service, the cli needs KEY_STORE=mongo")
+
+// openStore builds the config and connects to the key store it names, the caller closes the store,
+// tests swap it for one that shares a memory store between commands
+var openStore = func(ctx context.Context) (*config.Config, key.KeyStore, error) {
+	cfg, err := config.Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: %w", err)
+	}
+
+	store, err := connectStore(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, store, nil
+}
+
+func connectStore(ctx context.Context, cfg *config.Config) (key.KeyStore, error) {
+	if cfg.Local.KeyStore == key.StoreMemory {
+		return nil, errMemoryStore
+	}
+
+	store, err := key.NewStore(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("key store: %w", err)
+	}
+
+	return store, nil
+}
+
+func storeCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return fmt.Errorf("usage: key-service store migrate [-json]")
+	}
+
+	fs := flag.NewFlagSet("store migrate", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print json rather than a table")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	_, store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore(ctx, store)
+
+	migrated, err := key.Migrate(ctx, store)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	out := output{w: stdout, json: *asJSON}
+	return out.print(struct {
+		Migrated int64 `json:"migrated"`
+	}{migrated}, table{
+		header: []string{"MIGRATED"},
+		rows:   [][]string{{fmt.Sprint(migrated)}},
+	})
+}
+
+func closeStore(ctx context.Context, store key.KeyStore) {
+	if err := store.Close(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "close key store: %v\n", err)
+	}
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+)
+
+// ErrQueueFull is returned when an event is dropped because the sink is too far behind
+var ErrQueueFull = errors.New("audit queue is full, event dropped")
+
+// ErrClosed is returned for events recorded after the queue has been drained
+var ErrClosed = errors.New("audit queue is closed, event dropped")
+
+// Async writes events to the sink from a bounded queue, so a slow sink can't hold up the operation being audited
+type Async struct {
+	sink    Sink
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+}
+
+// NewAsync starts the writer, each event gets timeout to be written before it is logged and dropped
+func NewAsync(sink Sink, size int, timeout time.Duration) *Async {
+	a := &Async{
+		sink:    sink,
+		timeout: timeout,
+		queue:   make(chan Event, size),
+		done:    make(chan struct{}),
+	}
+	go a.write()
+
+	return a
+}
+
+func (a *Async) write() {
+	defer close(a.done)
+	for event := range a.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+		if err := a.sink.Record(ctx, event); err != nil {
+			bugLog.Info(err)
+		}
+		cancel()
+	}
+}
+
+// Record queues the event, it never waits for the sink
+func (a *Async) Record(ctx context.Context, event Event) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrClosed
+	}
+
+	select {
+	case a.queue <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (a *Async) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	return a.sink.Query(ctx, filter)
+}
+
+// Close stops taking events and waits for the queued ones to be written, or for ctx to be done
+func (a *Async) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close drains the sink when it queues events, sinks that write straight away have nothing to do
+func Close(ctx context.Context, sink Sink) error {
+	if a, ok := sink.(*Async); ok {
+		return a.Close(ctx)
+	}
+
+	return nil
+}
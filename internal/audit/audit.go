@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+const (
+	SinkMongo  = "mongo"
+	SinkMemory = "memory"
+	SinkLog    = "log"
+	SinkNone   = "none"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// ErrNotQueryable is returned by sinks that only write, the events have to be read wherever they were sent
+var ErrNotQueryable = errors.New("audit sink can't be queried")
+
+// Event is one key operation, who asked for it, on whose keys and how it went
+type Event struct {
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	RequestID string    `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Transport string    `json:"transport" bson:"transport"`
+	Caller    string    `json:"caller,omitempty" bson:"caller,omitempty"`
+	Operation string    `json:"operation" bson:"operation"`
+	UserID    string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Service   string    `json:"service,omitempty" bson:"service,omitempty"`
+	Outcome   string    `json:"outcome" bson:"outcome"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
+// Filter narrows a query, an empty field doesn't filter, From is inclusive and To exclusive
+type Filter struct {
+	UserID string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		return MaxLimit
+	}
+
+	return f.Limit
+}
+
+func (f Filter) matches(e Event) bool {
+	if f.UserID != "" && e.UserID != f.UserID {
+		return false
+	}
+	if !f.From.IsZero() && e.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Timestamp.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+// Sink is where events are written, there is no way to change or remove one once it is written
+type Sink interface {
+	Record(ctx context.Context, event Event) error
+	// Query returns the newest events first
+	Query(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+type Memory struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Record(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *Memory) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]Event, 0)
+	for _, e := range m.events {
+		if filter.matches(e) {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+	if len(events) > filter.limit() {
+		events = events[:filter.limit()]
+	}
+
+	return events, nil
+}
+
+// Log writes each event as a line of json, for when the audit trail is collected with the rest of the logs
+type Log struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLog(w io.Writer) *Log {
+	return &Log{
+		w: w,
+	}
+}
+
+func (l *Log) Record(ctx context.Context, event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return json.NewEncoder(l.w).Encode(event)
+}
+
+func (l *Log) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	return nil, ErrNotQueryable
+}
+
+// Nop drops every event, for when auditing is turned off
+type Nop struct{}
+
+func (Nop) Record(ctx context.Context, event Event) error {
+	return nil
+}
+
+func (Nop) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	return nil, ErrNotQueryable
+}
@@ -0,0 +1,149 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/audit"
+)
+
+func TestMemory_Query(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	m := audit.NewMemory()
+	for i, userID := range []string{"tester", "bob", "tester", "tester"} {
+		if err := m.Record(ctx, audit.Event{
+			Timestamp: start.Add(time.Minute * time.Duration(i)),
+			Operation: "create",
+			UserID:    userID,
+			Outcome:   audit.OutcomeSuccess,
+		}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter audit.Filter
+		want   []time.Time
+	}{
+		{
+			name:   "test_user",
+			filter: audit.Filter{UserID: "tester"},
+			want:   []time.Time{start.Add(time.Minute * 3), start.Add(time.Minute * 2), start},
+		},
+		{
+			name:   "test_time_range",
+			filter: audit.Filter{From: start.Add(time.Minute), To: start.Add(time.Minute * 3)},
+			want:   []time.Time{start.Add(time.Minute * 2), start.Add(time.Minute)},
+		},
+		{
+			name:   "test_limit",
+			filter: audit.Filter{Limit: 1},
+			want:   []time.Time{start.Add(time.Minute * 3)},
+		},
+		{
+			name:   "test_no_match",
+			filter: audit.Filter{UserID: "alice"},
+			want:   []time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := m.Query(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if len(events) != len(tt.want) {
+				t.Fatalf("got: %d events, want: %d", len(events), len(tt.want))
+			}
+			for i := range events {
+				if !events[i].Timestamp.Equal(tt.want[i]) {
+					t.Errorf("got: %v, want: %v", events[i].Timestamp, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLog_Record(t *testing.T) {
+	var buf bytes.Buffer
+	l := audit.NewLog(&buf)
+
+	if err := l.Record(context.Background(), audit.Event{Operation: "revoke", UserID: "tester"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	var got audit.Event
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Operation != "revoke" || got.UserID != "tester" {
+		t.Errorf("got: %+v, want: the revoke for tester", got)
+	}
+
+	if _, err := l.Query(context.Background(), audit.Filter{}); !errors.Is(err, audit.ErrNotQueryable) {
+		t.Errorf("got: %v, want: %v", err, audit.ErrNotQueryable)
+	}
+}
+
+// slowSink holds every write until release is closed, started gets each event as its write begins
+type slowSink struct {
+	*audit.Memory
+	started chan string
+	release chan struct{}
+}
+
+func (s *slowSink) Record(ctx context.Context, event audit.Event) error {
+	s.started <- event.UserID
+	<-s.release
+	return s.Memory.Record(ctx, event)
+}
+
+func TestAsync_Record(t *testing.T) {
+	ctx := context.Background()
+	sink := &slowSink{
+		Memory:  audit.NewMemory(),
+		started: make(chan string, 3),
+		release: make(chan struct{}),
+	}
+	a := audit.NewAsync(sink, 1, time.Second)
+
+	if err := a.Record(ctx, audit.Event{UserID: "first"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	<-sink.started
+
+	// the writer is stuck on the first event, so the second fills the queue and the third is dropped
+	if err := a.Record(ctx, audit.Event{UserID: "second"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := a.Record(ctx, audit.Event{UserID: "third"}); !errors.Is(err, audit.ErrQueueFull) {
+		t.Errorf("got: %v, want: %v", err, audit.ErrQueueFull)
+	}
+
+	close(sink.release)
+	closeCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	if err := a.Close(closeCtx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	events, err := a.Query(ctx, audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got: %d events, want: the 2 queued before close", len(events))
+	}
+
+	if err := a.Record(ctx, audit.Event{UserID: "late"}); !errors.Is(err, audit.ErrClosed) {
+		t.Errorf("got: %v, want: %v", err, audit.ErrClosed)
+	}
+}
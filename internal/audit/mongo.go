@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo keeps the events in their own collection next to the keys, it only ever inserts
+type Mongo struct {
+	// client is a func so the sink follows the key store when it reconnects with new credentials
+	client func() *mongo.Client
+}
+
+func NewMongo(ctx context.Context, client func() *mongo.Client) (*Mongo, error) {
+	m := &Mongo{
+		client: client,
+	}
+
+	_, err := m.collection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Mongo) collection() *mongo.Collection {
+	return m.client().Database("keys").Collection("audit")
+}
+
+func (m *Mongo) Record(ctx context.Context, event Event) error {
+	_, err := m.collection().InsertOne(ctx, event)
+	return err
+}
+
+func (m *Mongo) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	query := bson.M{}
+	if filter.UserID != "" {
+		query["user_id"] = filter.UserID
+	}
+	timestamp := bson.M{}
+	if !filter.From.IsZero() {
+		timestamp["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		timestamp["$lt"] = filter.To
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	cursor, err := m.collection().Find(ctx, query, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(int64(filter.limit())))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
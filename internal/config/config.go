@@ -1,34 +1,85 @@
 package config
 
 import (
+	"os"
+	"sync"
+
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
-	"github.com/caarlos0/env/v6"
 )
 
 type Config struct {
 	Local
 	Mongo
 	Vault
+
+	Secrets Secrets `json:"secrets"`
+	secrets SecretsProvider
+
+	// mongoMu guards the mongo credentials, they are swapped when vault rotates them
+	mongoMu sync.RWMutex
+
+	// environment is the config file with env laid over it, nil when there is no file
+	environment map[string]string
 }
 
 func Build() (*Config, error) {
 	cfg := &Config{}
 
-	if err := env.Parse(cfg); err != nil {
-		return nil, bugLog.Error(err)
+	if path := os.Getenv(ConfigFileEnv); path != "" {
+		environment, err := LoadEnvironment(path)
+		if err != nil {
+			return nil, bugLog.Error(err)
+		}
+		cfg.environment = environment
 	}
 
-	if err := BuildMongo(cfg); err != nil {
+	if err := cfg.parseEnv(cfg); err != nil {
 		return nil, bugLog.Error(err)
 	}
 
-	if err := BuildVault(cfg); err != nil {
-		return nil, bugLog.Error(err)
+	// the settings are checked before anything is read, so a missing secret doesn't hide them
+	var p problems
+	servicesBuilt := true
+	if err := BuildServices(cfg); err != nil {
+		p.addError(err)
+		servicesBuilt = false
+	}
+	cfg.validateSettings(&p)
+
+	if servicesBuilt && cfg.secretsReadable() && cfg.buildFromSecrets(&p) {
+		cfg.validateSecretValues(&p)
 	}
 
-	if err := BuildLocal(cfg); err != nil {
+	if err := p.err(); err != nil {
 		return nil, bugLog.Error(err)
 	}
 
 	return cfg, nil
 }
+
+// buildFromSecrets runs the builders that read secrets, it says whether they all worked
+func (c *Config) buildFromSecrets(p *problems) bool {
+	// secrets and vault first, mongo and local both read their secrets through them
+	if err := BuildSecrets(c); err != nil {
+		p.addError(err)
+		return false
+	}
+	if err := BuildVault(c); err != nil {
+		p.addError(err)
+		return false
+	}
+
+	built := true
+	if knownMongoCredentials(c.Mongo.Credentials) {
+		if err := BuildMongo(c); err != nil {
+			p.addError(err)
+			built = false
+		}
+	}
+	if err := BuildLocal(c); err != nil {
+		p.addError(err)
+		built = false
+	}
+
+	return built
+}
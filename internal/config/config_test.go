@@ -1,30 +1,50 @@
 package config_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/caarlos0/env/v6"
 	"github.com/retro-board/key-service/internal/config"
 )
 
-func TestServiceKey(t *testing.T) {
-	if os.Getenv("VAULT_ADDRESS") == "" {
-		t.Skip("VAULT_ADDRESS not set")
-	}
-	if os.Getenv("VAULT_TOKEN") == "" {
-		t.Skip("VAULT_TOKEN not set")
-	}
-	if os.Getenv("ONE_PASSWORD_KEY") == "" {
-		t.Skip("ONE_PASSWORD_KEY not set")
-	}
+const testSecrets = `
+kv/data/retro-board/key-service-mongodb:
+  host: mongo.test
+  username: tester
+  password: mongoPass
+kv/data/retro-board/api-keys:
+  user: userKey
+  retro: retroKey
+  key: timerKey
+  company: companyKey
+  billing: billingKey
+  permission: permissionKey
+kv/data/retro-board/one-password:
+  password: sharedKey
+kv/data/retro-board/key-service-pepper:
+  pepper: pepper
+`
 
-	cfg := &config.Config{}
+func secretsFile(t *testing.T, name, contents string) string {
+	t.Helper()
 
-	if err := env.Parse(cfg); err != nil {
-		t.Errorf("parse env: %v", err)
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
 	}
 
+	return path
+}
+
+func TestServiceKey(t *testing.T) {
+	t.Setenv("SECRETS_PROVIDER", config.SecretsFile)
+	t.Setenv("SECRETS_FILE", secretsFile(t, "secrets.yaml", testSecrets))
+
 	tests := []struct {
 		name string
 		cfg  *config.Config
@@ -39,32 +59,22 @@ func TestServiceKey(t *testing.T) {
 			},
 			want: "tester",
 		},
-		{
-			name: "env service key",
-			cfg: &config.Config{
-				Local: config.Local{
-					OnePasswordKey: os.Getenv("ONE_PASSWORD_KEY"),
-				},
-			},
-			want: os.Getenv("ONE_PASSWORD_KEY"),
-		},
 		{
 			name: "retrieve service key",
 			cfg: &config.Config{
 				Local: config.Local{
-					OnePasswordPath: os.Getenv("ONE_PASSWORD_PATH"),
-				},
-				Vault: config.Vault{
-					Address: os.Getenv("VAULT_ADDRESS"),
-					Token:   os.Getenv("VAULT_TOKEN"),
+					OnePasswordPath: "kv/data/retro-board/one-password",
 				},
 			},
-			want: os.Getenv("ONE_PASSWORD_KEY"),
+			want: "sharedKey",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			if err := config.BuildSecrets(tt.cfg); err != nil {
+				t.Fatalf("BuildSecrets: %v", err)
+			}
 			if err := config.BuildServiceKey(tt.cfg); err != nil {
 				t.Errorf("BuildServiceKey: %v", err)
 			}
@@ -74,3 +84,132 @@ func TestServiceKey(t *testing.T) {
 		})
 	}
 }
+
+func TestBuild(t *testing.T) {
+	t.Setenv("SECRETS_PROVIDER", config.SecretsFile)
+	t.Setenv("SECRETS_FILE", secretsFile(t, "secrets.yaml", testSecrets))
+	t.Setenv("ONE_PASSWORD_PATH", "kv/data/retro-board/one-password")
+
+	cfg, err := config.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if cfg.Mongo.Host != "mongo.test" || cfg.Mongo.Password != "mongoPass" {
+		t.Errorf("got: %v, want: the mongo details from the secrets file", cfg.Mongo.Host)
+	}
+	if cfg.Local.OnePasswordKey != "sharedKey" {
+		t.Errorf("got: %v, want: %v", cfg.Local.OnePasswordKey, "sharedKey")
+	}
+	if cfg.Local.KeyPepper != "pepper" {
+		t.Errorf("got: %v, want: %v", cfg.Local.KeyPepper, "pepper")
+	}
+	if s, _ := cfg.Local.Services.Get("timer"); s.Key != "timerKey" {
+		t.Errorf("got: %v, want: %v", s.Key, "timerKey")
+	}
+	if cfg.VaultClient() != nil {
+		t.Errorf("got: a vault client, want: none without the vault provider")
+	}
+}
+
+func TestBuild_MemoryStore(t *testing.T) {
+	// without the mongo store there is no mongodb secret to read
+	secrets := strings.Replace(testSecrets, "kv/data/retro-board/key-service-mongodb:\n  host: mongo.test\n  username: tester\n  password: mongoPass\n", "", 1)
+	t.Setenv("SECRETS_PROVIDER", config.SecretsFile)
+	t.Setenv("SECRETS_FILE", secretsFile(t, "secrets.yaml", secrets))
+	t.Setenv("ONE_PASSWORD_PATH", "kv/data/retro-board/one-password")
+	t.Setenv("KEY_STORE", "memory")
+
+	cfg, err := config.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if cfg.Mongo.Password != "" {
+		t.Errorf("got: %v, want: no mongo secret read with the memory store", cfg.Mongo.Password)
+	}
+}
+
+func TestSecretsProviders(t *testing.T) {
+	t.Setenv("SECRETS_API_KEYS_USER", "userKey")
+	t.Setenv("SECRETS_API_KEYS_TIMER", "timerKey")
+
+	tests := []struct {
+		name     string
+		provider config.SecretsProvider
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "test_env",
+			provider: &config.EnvSecrets{Prefix: "SECRETS"},
+			want:     map[string]string{"user": "userKey", "timer": "timerKey"},
+		},
+		{
+			name:     "test_env_missing",
+			provider: &config.EnvSecrets{Prefix: "MISSING"},
+			wantErr:  true,
+		},
+		{
+			name: "test_json_file",
+			provider: &config.FileSecrets{Path: secretsFile(t, "secrets.json",
+				`{"kv/data/retro-board/api-keys": {"user": "userKey", "timer": "timerKey"}}`)},
+			want: map[string]string{"user": "userKey", "timer": "timerKey"},
+		},
+		{
+			name: "test_yaml_file",
+			provider: &config.FileSecrets{Path: secretsFile(t, "secrets.yml",
+				"kv/data/retro-board/api-keys:\n  user: userKey\n  timer: timerKey\n")},
+			want: map[string]string{"user": "userKey", "timer": "timerKey"},
+		},
+		{
+			name:     "test_file_missing_path",
+			provider: &config.FileSecrets{Path: secretsFile(t, "empty.json", `{}`)},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.ReadSecret(context.Background(), "kv/data/retro-board/api-keys")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildServices(t *testing.T) {
+	t.Setenv("PERMISSION_SERVICE_KEY", "permissionKey")
+	t.Setenv("AUDIT_SERVICE_KEY", "auditKey")
+	t.Setenv("AUDIT_SERVICE_KEY_TTL", "10m")
+
+	cfg := &config.Config{
+		Local: config.Local{
+			ServiceNames: []string{"user", "permissions", "audit"},
+		},
+	}
+	if err := config.BuildServices(cfg); err != nil {
+		t.Fatalf("BuildServices: %v", err)
+	}
+
+	if got := cfg.Local.Services.Names(); len(got) != 3 {
+		t.Errorf("got: %v, want: %v", got, cfg.Local.ServiceNames)
+	}
+	if s, _ := cfg.Local.Services.Get("permissions"); s.Key != "permissionKey" {
+		t.Errorf("got: %v, want: %v", s.Key, "permissionKey")
+	}
+	if s, _ := cfg.Local.Services.Get("audit"); s.Key != "auditKey" || s.KeyTTL != time.Minute*10 {
+		t.Errorf("got: %v %v, want: %v %v", s.Key, s.KeyTTL, "auditKey", time.Minute*10)
+	}
+	if s, _ := cfg.Local.Services.Get("user"); s.Address == "" {
+		t.Errorf("got: no address, want the user service default")
+	}
+
+	cfg.Local.ServiceNames = []string{"user", "user"}
+	if err := config.BuildServices(cfg); err == nil {
+		t.Errorf("BuildServices: want an error for a duplicate service")
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the optional config file, its keys are the same names as the env vars
+const ConfigFileEnv = "CONFIG_FILE"
+
+// LoadEnvironment is the config file with the real env laid over it, so anything set in env wins
+func LoadEnvironment(path string) (map[string]string, error) {
+	environment := make(map[string]string)
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			environment[k] = v
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			environment[name] = value
+		}
+	}
+
+	return environment, nil
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &values)
+	case ".toml":
+		err = toml.Unmarshal(contents, &values)
+	default:
+		return nil, fmt.Errorf("unknown config file type: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	environment := make(map[string]string, len(values))
+	for k, v := range values {
+		environment[strings.ToUpper(k)] = fileValue(v)
+	}
+
+	return environment, nil
+}
+
+// fileValue turns a file value into what the env var would have been, lists become comma separated
+func fileValue(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", v)
+	}
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		values = append(values, fmt.Sprintf("%v", item))
+	}
+
+	return strings.Join(values, ",")
+}
+
+// parseEnv fills v from the layered environment, or the real env when there isn't one
+func (c *Config) parseEnv(v interface{}) error {
+	if c.environment == nil {
+		return env.Parse(v)
+	}
+
+	return env.Parse(v, env.Options{Environment: c.environment})
+}
+
+func (c *Config) getenv(name string) string {
+	if c.environment == nil {
+		return os.Getenv(name)
+	}
+
+	return c.environment[name]
+}
@@ -0,0 +1,77 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func TestBuild_ConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+	}{
+		{
+			name: "test_yaml",
+			file: "config.yaml",
+			contents: `
+SECRETS_PROVIDER: file
+ONE_PASSWORD_PATH: kv/data/retro-board/one-password
+HTTP_PORT: 4000
+GRPC_PORT: 9000
+KEY_TTL: 3h
+auth_create: [user, retro]
+`,
+		},
+		{
+			name: "test_toml",
+			file: "config.toml",
+			contents: `
+SECRETS_PROVIDER = "file"
+ONE_PASSWORD_PATH = "kv/data/retro-board/one-password"
+HTTP_PORT = 4000
+GRPC_PORT = 9000
+KEY_TTL = "3h"
+AUTH_CREATE = ["user", "retro"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SECRETS_FILE", secretsFile(t, "secrets.yaml", testSecrets))
+			t.Setenv(config.ConfigFileEnv, secretsFile(t, tt.file, tt.contents))
+			// env always wins over the file
+			t.Setenv("GRPC_PORT", "9100")
+
+			cfg, err := config.Build()
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+
+			if cfg.Local.HTTPPort != 4000 {
+				t.Errorf("got: %v, want: %v", cfg.Local.HTTPPort, 4000)
+			}
+			if cfg.Local.GRPCPort != 9100 {
+				t.Errorf("got: %v, want: %v", cfg.Local.GRPCPort, 9100)
+			}
+			if cfg.Local.KeyTTL != time.Hour*3 {
+				t.Errorf("got: %v, want: %v", cfg.Local.KeyTTL, time.Hour*3)
+			}
+			if got := cfg.Local.Authorization.Create; len(got) != 2 || got[1] != "retro" {
+				t.Errorf("got: %v, want: %v", got, []string{"user", "retro"})
+			}
+			if cfg.Mongo.Host != "mongo.test" {
+				t.Errorf("got: %v, want: %v", cfg.Mongo.Host, "mongo.test")
+			}
+		})
+	}
+}
+
+func TestLoadEnvironment_UnknownType(t *testing.T) {
+	if _, err := config.LoadEnvironment(secretsFile(t, "config.ini", "HTTP_PORT=4000")); err == nil {
+		t.Errorf("LoadEnvironment: want an error for an ini file")
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SharedKeyName is what the one password key is called when reporting a rotation
+const SharedKeyName = "shared"
+
+// ServiceKeys is one read of the keys other services present when calling us
+type ServiceKeys struct {
+	Services map[string]string
+	Shared   string
+}
+
+// changed lists the services whose key is different in next, sorted
+func (s ServiceKeys) changed(next ServiceKeys) []string {
+	var changed []string
+	for name, key := range next.Services {
+		if s.Services[name] != key {
+			changed = append(changed, name)
+		}
+	}
+	for name := range s.Services {
+		if _, ok := next.Services[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	if s.Shared != next.Shared {
+		changed = append(changed, SharedKeyName)
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+type keyringState struct {
+	current       ServiceKeys
+	previous      *ServiceKeys
+	previousUntil time.Time
+}
+
+// Keyring lets the refresher swap the service keys while requests are reading them,
+// the keys it replaced are still accepted until the overlap runs out
+type Keyring struct {
+	mu      sync.Mutex
+	state   atomic.Value
+	overlap time.Duration
+}
+
+func NewKeyring(keys ServiceKeys, overlap time.Duration) *Keyring {
+	k := &Keyring{
+		overlap: overlap,
+	}
+	k.state.Store(keyringState{current: keys})
+
+	return k
+}
+
+func (k *Keyring) load() keyringState {
+	return k.state.Load().(keyringState)
+}
+
+func (k *Keyring) Current() ServiceKeys {
+	return k.load().current
+}
+
+// Accepted is every set of keys a caller can use right now, the current set first
+func (k *Keyring) Accepted() []ServiceKeys {
+	state := k.load()
+	if state.previous == nil || !time.Now().Before(state.previousUntil) {
+		return []ServiceKeys{state.current}
+	}
+
+	return []ServiceKeys{state.current, *state.previous}
+}
+
+// Rotate swaps in the new keys and returns which ones changed, nothing is swapped if none did
+func (k *Keyring) Rotate(keys ServiceKeys) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	state := k.load()
+	changed := state.current.changed(keys)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	previous := state.current
+	k.state.Store(keyringState{
+		current:       keys,
+		previous:      &previous,
+		previousUntil: time.Now().Add(k.overlap),
+	})
+
+	return changed
+}
@@ -0,0 +1,116 @@
+package config_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func TestKeyring_Rotate(t *testing.T) {
+	tests := []struct {
+		name     string
+		overlap  time.Duration
+		next     config.ServiceKeys
+		changed  []string
+		accepted int
+	}{
+		{
+			name:     "test_unchanged",
+			overlap:  time.Minute,
+			next:     config.ServiceKeys{Services: map[string]string{"user": "userKey"}, Shared: "sharedKey"},
+			accepted: 1,
+		},
+		{
+			name:     "test_rotated_in_overlap",
+			overlap:  time.Minute,
+			next:     config.ServiceKeys{Services: map[string]string{"user": "newKey"}, Shared: "newShared"},
+			changed:  []string{config.SharedKeyName, "user"},
+			accepted: 2,
+		},
+		{
+			name:     "test_rotated_without_overlap",
+			next:     config.ServiceKeys{Services: map[string]string{"user": "newKey"}, Shared: "sharedKey"},
+			changed:  []string{"user"},
+			accepted: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := config.NewKeyring(config.ServiceKeys{
+				Services: map[string]string{"user": "userKey"},
+				Shared:   "sharedKey",
+			}, tt.overlap)
+
+			if changed := k.Rotate(tt.next); !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("Keyring.Rotate() = %v, want %v", changed, tt.changed)
+			}
+			if accepted := k.Accepted(); len(accepted) != tt.accepted {
+				t.Errorf("Keyring.Accepted() = %v sets, want %v", len(accepted), tt.accepted)
+			}
+			if !reflect.DeepEqual(k.Current(), tt.next) {
+				t.Errorf("Keyring.Current() = %v, want %v", k.Current(), tt.next)
+			}
+		})
+	}
+}
+
+func TestConfig_RefreshServiceKeys(t *testing.T) {
+	var mu sync.Mutex
+	userKey := "userKey"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/kv/data/retro-board/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"data":{"user":"` + userKey + `"}}}`))
+	})
+	vault := httptest.NewServer(mux)
+	defer vault.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address: vault.URL,
+		},
+		Local: config.Local{
+			ServiceKeyRefresh: time.Millisecond * 10,
+			ServiceKeyOverlap: time.Minute,
+			Services:          config.Services{config.NewService("user")},
+		},
+	}
+	if err := cfg.Vault.Authenticate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Local.Keyring = config.NewKeyring(config.ServiceKeys{
+		Services: map[string]string{"user": "userKey"},
+	}, cfg.Local.ServiceKeyOverlap)
+	go cfg.RefreshServiceKeys(ctx)
+
+	mu.Lock()
+	userKey = "rotatedKey"
+	mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for cfg.Local.Keyring.Current().Services["user"] != "rotatedKey" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	accepted := cfg.Local.AcceptedServiceKeys()
+	if len(accepted) != 2 {
+		t.Fatalf("Local.AcceptedServiceKeys() = %v sets, want 2", len(accepted))
+	}
+	if accepted[0].Services["user"] != "rotatedKey" || accepted[1].Services["user"] != "userKey" {
+		t.Errorf("Local.AcceptedServiceKeys() = %v, want the rotated key then the old one", accepted)
+	}
+}
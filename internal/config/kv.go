@@ -0,0 +1,243 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	vaultAPI "github.com/hashicorp/vault/api"
+)
+
+// SecretNotFoundError is returned when there is nothing at the path, or at the version asked for
+type SecretNotFoundError struct {
+	Path    string
+	Version int
+}
+
+func (e *SecretNotFoundError) Error() string {
+	if e.Version > 0 {
+		return fmt.Sprintf("no secret at path: %s, version: %d", e.Path, e.Version)
+	}
+
+	return fmt.Sprintf("no secret at path: %s", e.Path)
+}
+
+// MissingKeyError is returned when the secret exists but doesn't have a key that is needed
+type MissingKeyError struct {
+	Path string
+	Key  string
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("secret at path: %s has no %s", e.Path, e.Key)
+}
+
+// RequireKeys fails with a MissingKeyError for the first key that is missing or empty
+func RequireKeys(path string, secrets map[string]string, keys ...string) error {
+	for _, key := range keys {
+		if secrets[key] == "" {
+			return &MissingKeyError{Path: path, Key: key}
+		}
+	}
+
+	return nil
+}
+
+// KVPath is a secret path split into its mount and the path inside it,
+// kv/data/retro-board/api-keys and kv/retro-board/api-keys are the same secret on a v2 mount
+type KVPath struct {
+	Mount     string
+	Path      string
+	KVVersion int
+
+	// Version is the secret version to read, 0 for the latest, only v2 mounts keep versions
+	Version int
+}
+
+func (p KVPath) String() string {
+	return p.Mount + "/" + p.Path
+}
+
+// apiPath is what gets sent to vault for the path
+func (p KVPath) apiPath() string {
+	if p.KVVersion == 2 {
+		return p.Mount + "/data/" + p.Path
+	}
+
+	return p.String()
+}
+
+type kvMount struct {
+	path    string
+	version int
+}
+
+// KVReader reads kv secrets, working out the version of each mount the first time it is used
+type KVReader struct {
+	client *vaultAPI.Client
+
+	mu     sync.Mutex
+	mounts map[string]kvMount
+}
+
+func NewKVReader(client *vaultAPI.Client) *KVReader {
+	return &KVReader{
+		client: client,
+		mounts: make(map[string]kvMount),
+	}
+}
+
+// splitVersion takes a ?version=N suffix off the path
+func splitVersion(path string) (string, int, error) {
+	path, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path, 0, nil
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return path, 0, err
+	}
+	if values.Get("version") == "" {
+		return path, 0, nil
+	}
+
+	version, err := strconv.Atoi(values.Get("version"))
+	if err != nil || version < 0 {
+		return path, 0, fmt.Errorf("invalid secret version: %s", values.Get("version"))
+	}
+
+	return path, version, nil
+}
+
+func (r *KVReader) mount(ctx context.Context, path string) (kvMount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for prefix, m := range r.mounts {
+		if strings.HasPrefix(path, prefix+"/") {
+			return m, nil
+		}
+	}
+
+	m, detected, err := r.detectMount(ctx, path)
+	if err != nil {
+		return kvMount{}, err
+	}
+	// a guess isn't kept, the next read asks vault again
+	if detected {
+		r.mounts[m.path] = m
+	}
+
+	return m, nil
+}
+
+// detectMount asks vault about the mount the same way the vault cli does, if the token isn't allowed to
+// ask, or vault doesn't know the mount, then a data/ segment after the mount is taken to mean v2.
+// detected is false when the mount is a guess
+func (r *KVReader) detectMount(ctx context.Context, path string) (m kvMount, detected bool, err error) {
+	segments := strings.SplitN(path, "/", 3)
+	fallback := kvMount{path: segments[0], version: 1}
+	if len(segments) > 2 && segments[1] == "data" {
+		fallback.version = 2
+	}
+
+	secret, err := r.client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+path)
+	if err != nil {
+		var respErr *vaultAPI.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+			return fallback, false, nil
+		}
+		return kvMount{}, false, fmt.Errorf("kv mount lookup for %s: %w", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return fallback, false, nil
+	}
+
+	mountPath, _ := secret.Data["path"].(string)
+	mountPath = strings.Trim(mountPath, "/")
+	if mountPath == "" {
+		return fallback, false, nil
+	}
+
+	m = kvMount{path: mountPath, version: 1}
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if version, _ := options["version"].(string); version == "2" {
+			m.version = 2
+		}
+	}
+
+	return m, true, nil
+}
+
+// Resolve normalizes the path, splitting off the mount and any version asked for
+func (r *KVReader) Resolve(ctx context.Context, path string) (KVPath, error) {
+	path, version, err := splitVersion(strings.Trim(path, "/"))
+	if err != nil {
+		return KVPath{}, err
+	}
+
+	m, err := r.mount(ctx, path)
+	if err != nil {
+		return KVPath{}, err
+	}
+	logical := strings.TrimPrefix(strings.TrimPrefix(path, m.path), "/")
+	if m.version == 2 {
+		logical = strings.TrimPrefix(logical, "data/")
+	}
+	if logical == "" {
+		return KVPath{}, fmt.Errorf("no secret path after mount: %s", m.path)
+	}
+	if version > 0 && m.version != 2 {
+		return KVPath{}, fmt.Errorf("secret versions need a kv v2 mount: %s", m.path)
+	}
+
+	return KVPath{
+		Mount:     m.path,
+		Path:      logical,
+		KVVersion: m.version,
+		Version:   version,
+	}, nil
+}
+
+func (r *KVReader) Read(ctx context.Context, path string) (map[string]string, error) {
+	p, err := r.Resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var query map[string][]string
+	if p.Version > 0 {
+		query = map[string][]string{"version": {strconv.Itoa(p.Version)}}
+	}
+
+	secret, err := r.client.Logical().ReadWithDataWithContext(ctx, p.apiPath(), query)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, &SecretNotFoundError{Path: p.String(), Version: p.Version}
+	}
+
+	data := secret.Data
+	if p.KVVersion == 2 {
+		// a deleted or destroyed version comes back with metadata but no data
+		nested, ok := data["data"].(map[string]interface{})
+		if !ok {
+			return nil, &SecretNotFoundError{Path: p.String(), Version: p.Version}
+		}
+		data = nested
+	}
+
+	secrets := make(map[string]string, len(data))
+	for k, v := range data {
+		secrets[k] = fmt.Sprintf("%v", v)
+	}
+
+	return secrets, nil
+}
@@ -0,0 +1,205 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+// vaultNotFound answers the way vault does for a path with nothing at it
+func vaultNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"errors":[]}`))
+}
+
+func testKVVault(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	respond := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/sys/internal/ui/mounts/kv/", respond(`{"data":{"path":"kv/","type":"kv","options":{"version":"2"}}}`))
+	mux.HandleFunc("/v1/sys/internal/ui/mounts/secret/", respond(`{"data":{"path":"secret/","type":"kv","options":{}}}`))
+	mux.HandleFunc("/v1/kv/data/retro-board/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("version") {
+		case "", "2":
+			_, _ = w.Write([]byte(`{"data":{"data":{"user":"userKey"},"metadata":{"version":2}}}`))
+		case "1":
+			_, _ = w.Write([]byte(`{"data":{"data":{"user":"oldKey"},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	})
+	mux.HandleFunc("/v1/secret/retro-board/one-password", respond(`{"data":{"password":"sharedKey"}}`))
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestKVReader_Read(t *testing.T) {
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address: testKVVault(t).URL,
+		},
+	}
+	if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		want     map[string]string
+		notFound bool
+		wantErr  bool
+	}{
+		{
+			name: "test_v2_data_path",
+			path: "kv/data/retro-board/api-keys",
+			want: map[string]string{"user": "userKey"},
+		},
+		{
+			name: "test_v2_logical_path",
+			path: "/kv/retro-board/api-keys/",
+			want: map[string]string{"user": "userKey"},
+		},
+		{
+			name: "test_v2_version",
+			path: "kv/retro-board/api-keys?version=1",
+			want: map[string]string{"user": "oldKey"},
+		},
+		{
+			name:     "test_v2_missing_version",
+			path:     "kv/retro-board/api-keys?version=9",
+			notFound: true,
+		},
+		{
+			name: "test_v1",
+			path: "secret/retro-board/one-password",
+			want: map[string]string{"password": "sharedKey"},
+		},
+		{
+			name:    "test_v1_version",
+			path:    "secret/retro-board/one-password?version=1",
+			wantErr: true,
+		},
+		{
+			name:     "test_missing_secret",
+			path:     "kv/retro-board/bob",
+			notFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.KV().Read(context.Background(), tt.path)
+
+			var notFound *config.SecretNotFoundError
+			if errors.As(err, &notFound) != tt.notFound {
+				t.Fatalf("KVReader.Read() error = %v, want not found %v", err, tt.notFound)
+			}
+			if (err != nil) != (tt.wantErr || tt.notFound) {
+				t.Fatalf("KVReader.Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVReader.Read() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKVReader_MountLookup(t *testing.T) {
+	var mu sync.Mutex
+	lookups := 0
+	// the token can't ask at first, then vault has a bad moment, then it answers
+	statuses := []int{http.StatusForbidden, http.StatusInternalServerError}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/sys/internal/ui/mounts/kv/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lookups++
+		n := lookups
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			_, _ = w.Write([]byte(`{"errors":["not now"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"path":"kv/","type":"kv","options":{"version":"2"}}}`))
+	})
+	mux.HandleFunc("/v1/kv/data/retro-board/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"data":{"user":"userKey"},"metadata":{"version":1}}}`))
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address: s.URL,
+		},
+	}
+	if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// without data/ in the path the forbidden lookup can only guess v1, so the secret isn't found
+	_, err := cfg.KV().Read(context.Background(), "kv/retro-board/api-keys")
+	var notFound *config.SecretNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("KVReader.Read() error = %v, want not found from the v1 guess", err)
+	}
+
+	if _, err := cfg.KV().Read(context.Background(), "kv/retro-board/api-keys"); err == nil || errors.As(err, &notFound) {
+		t.Fatalf("KVReader.Read() error = %v, want the failed mount lookup", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := cfg.KV().Read(context.Background(), "kv/retro-board/api-keys")
+		if err != nil {
+			t.Fatalf("KVReader.Read() error = %v", err)
+		}
+		if got["user"] != "userKey" {
+			t.Errorf("KVReader.Read() = %v, want userKey", got)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lookups != 3 {
+		t.Errorf("mount lookups = %d, want 3, only the one that worked should be kept", lookups)
+	}
+}
+
+func TestRequireKeys(t *testing.T) {
+	secrets := map[string]string{"host": "mongo.test", "username": ""}
+
+	if err := config.RequireKeys("mongo", secrets, "host"); err != nil {
+		t.Errorf("RequireKeys() error = %v, want nil", err)
+	}
+
+	err := config.RequireKeys("mongo", secrets, "host", "username", "password")
+	var missing *config.MissingKeyError
+	if !errors.As(err, &missing) || missing.Key != "username" {
+		t.Errorf("RequireKeys() error = %v, want the missing username", err)
+	}
+}
@@ -1,45 +1,39 @@
 package config
 
 import (
-	"fmt"
-
-	"github.com/caarlos0/env/v6"
+	"time"
 
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
 )
 
-type UserService struct {
-	Key     string `env:"USER_SERVICE_KEY"`
-	Address string `env:"USER_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/user"`
-}
-type CompanyService struct {
-	Key     string `env:"COMPANY_SERVICE_KEY"`
-	Address string `env:"COMPANY_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/company"`
-}
-type TimerService struct {
-	Key     string `env:"TIMER_SERVICE_KEY"`
-	Address string `env:"TIMER_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/key"`
-}
-type RetroService struct {
-	Key     string `env:"RETRO_SERVICE_KEY"`
-	Address string `env:"RETRO_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/retro"`
-}
-type BillingService struct {
-	Key     string `env:"BILLING_SERVICE_KEY"`
-	Address string `env:"BILLING_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/billing"`
+type Authorization struct {
+	Create   []string `env:"AUTH_CREATE" envDefault:"user" json:"create,omitempty"`
+	Get      []string `env:"AUTH_GET" envDefault:"user" json:"get,omitempty"`
+	Validate []string `env:"AUTH_VALIDATE" envDefault:"user,retro,timer,company,billing,permissions" json:"validate,omitempty"`
+	Revoke   []string `env:"AUTH_REVOKE" envDefault:"user" json:"revoke,omitempty"`
+	Rotate   []string `env:"AUTH_ROTATE" envDefault:"user" json:"rotate,omitempty"`
+	Audit    []string `env:"AUTH_AUDIT" envDefault:"user" json:"audit,omitempty"`
+
+	// Shared lists operations rather than services, the ones anything still on the shared key may do
+	Shared []string `env:"AUTH_SHARED" envDefault:"create,get,validate,revoke,rotate" json:"shared,omitempty"`
 }
-type PermissionService struct {
-	Key     string `env:"PERMISSION_SERVICE_KEY"`
-	Address string `env:"PERMISSION_SERVICE_ADDRESS" envDefault:"https://api.retro-board.it/v1/permission"`
+
+// Audit picks where the audit trail goes, without a sink it goes wherever the keys are stored
+type Audit struct {
+	Sink       string   `env:"AUDIT_SINK" json:"sink,omitempty"`
+	Operations []string `env:"AUDIT_OPERATIONS" envDefault:"create,get,validate,revoke,rotate" json:"operations,omitempty"`
+
+	// QueueSize is how many events can wait for the mongo sink before new ones are dropped
+	QueueSize int `env:"AUDIT_QUEUE_SIZE" envDefault:"1000" json:"queue_size,omitempty"`
 }
 
-type Services struct {
-	UserService
-	CompanyService
-	TimerService
-	RetroService
-	BillingService
-	PermissionService
+// Tracing is off unless an exporter is chosen, so nothing tries to reach a collector by default
+type Tracing struct {
+	Exporter    string  `env:"TRACING_EXPORTER" envDefault:"none" json:"exporter,omitempty"`
+	Endpoint    string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT" envDefault:"localhost:4317" json:"endpoint,omitempty"`
+	Insecure    bool    `env:"OTEL_EXPORTER_OTLP_INSECURE" envDefault:"false" json:"insecure,omitempty"`
+	ServiceName string  `env:"OTEL_SERVICE_NAME" envDefault:"key-service" json:"service_name,omitempty"`
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1" json:"sample_ratio,omitempty"`
 }
 
 type Local struct {
@@ -48,27 +42,71 @@ type Local struct {
 	HTTPPort    int  `env:"HTTP_PORT" envDefault:"3000" json:"port,omitempty"`
 	GRPCPort    int  `env:"GRPC_PORT" envDefault:"8001" json:"grpc_port,omitempty"`
 
+	// ShutdownDelay plus ShutdownTimeout is the longest shutdown can take, it has to stay under the pod's
+	// termination grace period
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"20s" json:"shutdown_timeout,omitempty"`
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" envDefault:"5s" json:"shutdown_delay,omitempty"`
+
+	HealthCacheTTL time.Duration `env:"HEALTH_CACHE_TTL" envDefault:"5s" json:"health_cache_ttl,omitempty"`
+	HealthTimeout  time.Duration `env:"HEALTH_TIMEOUT" envDefault:"2s" json:"health_timeout,omitempty"`
+
+	KeyStore      string        `env:"KEY_STORE" envDefault:"mongo" json:"key_store,omitempty"`
+	LegacyStatus  bool          `env:"GRPC_LEGACY_STATUS" envDefault:"true" json:"legacy_status,omitempty"`
+	KeyTTL        time.Duration `env:"KEY_TTL" envDefault:"2h" json:"key_ttl,omitempty"`
+	RotationGrace time.Duration `env:"KEY_ROTATION_GRACE" envDefault:"5m" json:"rotation_grace,omitempty"`
+
+	// KeyRetention is how long mongo keeps an expired key set, so it is reported as expired rather than not found
+	KeyRetention time.Duration `env:"KEY_RETENTION" envDefault:"24h" json:"key_retention,omitempty"`
+
 	OnePasswordKey  string `env:"ONE_PASSWORD_KEY" json:"one_password_key,omitempty"`
 	OnePasswordPath string `env:"ONE_PASSWORD_PATH" json:"one_password_path,omitempty"`
 
-	Services `json:"services"`
+	AllowSharedServiceKey bool          `env:"ALLOW_SHARED_SERVICE_KEY" envDefault:"true" json:"allow_shared_service_key,omitempty"`
+	Authorization         Authorization `json:"authorization"`
+
+	KeyPepper     string `env:"KEY_PEPPER" json:"key_pepper,omitempty"`
+	KeyPepperPath string `env:"KEY_PEPPER_PATH" envDefault:"kv/data/retro-board/key-service-pepper" json:"key_pepper_path,omitempty"`
+
+	Tracing Tracing `json:"tracing"`
+	Audit   Audit   `json:"audit"`
+
+	APIKeysPath       string        `env:"API_KEYS_PATH" envDefault:"kv/data/retro-board/api-keys" json:"api_keys_path,omitempty"`
+	ServiceKeyRefresh time.Duration `env:"SERVICE_KEY_REFRESH" envDefault:"5m" json:"service_key_refresh,omitempty"`
+	ServiceKeyOverlap time.Duration `env:"SERVICE_KEY_OVERLAP" envDefault:"10m" json:"service_key_overlap,omitempty"`
+	Keyring           *Keyring      `json:"-"`
+
+	// sharedKeyFromSecrets is set when the one password key wasn't given in env, so the refresher should re-read it
+	sharedKeyFromSecrets bool
+
+	ServiceNames []string `env:"SERVICES" envDefault:"user,retro,timer,company,billing,permissions" json:"-"`
+	Services     Services `json:"services"`
 }
 
 func BuildLocal(cfg *Config) error {
 	local := &Local{}
-	if err := env.Parse(local); err != nil {
+	if err := cfg.parseEnv(local); err != nil {
 		return err
 	}
 	cfg.Local = *local
 
+	if err := BuildServices(cfg); err != nil {
+		return bugLog.Errorf("failed to build services: %s", err.Error())
+	}
+
+	// the rest don't depend on each other, so every one of them is tried
+	var errs []error
 	if err := BuildServiceKeys(cfg); err != nil {
-		return bugLog.Errorf("failed to build service keys: %s", err.Error())
+		errs = append(errs, withContext("failed to build service keys", err))
 	}
 	if err := BuildServiceKey(cfg); err != nil {
-		return bugLog.Errorf("failed to build service key: %s", err.Error())
+		errs = append(errs, withContext("failed to build service key", err))
+	}
+	if err := BuildKeyPepper(cfg); err != nil {
+		errs = append(errs, withContext("failed to build key pepper", err))
 	}
+	cfg.Local.Keyring = NewKeyring(cfg.Local.serviceKeys(), cfg.Local.ServiceKeyOverlap)
 
-	return nil
+	return collectErrors(errs)
 }
 
 func BuildServiceKey(cfg *Config) error {
@@ -76,51 +114,56 @@ func BuildServiceKey(cfg *Config) error {
 		return nil
 	}
 
-	onePasswordKeyData, err := cfg.getVaultSecrets(cfg.Local.OnePasswordPath)
+	onePasswordKeyData, err := cfg.readSecret(cfg.Local.OnePasswordPath)
 	if err != nil {
 		return err
 	}
 
-	for ik, iv := range onePasswordKeyData {
-		if ik == "password" {
-			cfg.Local.OnePasswordKey = iv.(string)
-		}
+	if err := RequireKeys(cfg.Local.OnePasswordPath, onePasswordKeyData, "password"); err != nil {
+		return err
 	}
+
+	cfg.Local.OnePasswordKey = onePasswordKeyData["password"]
+	cfg.Local.sharedKeyFromSecrets = true
 	return nil
 }
 
-// nolint:gocyclo
-func BuildServiceKeys(cfg *Config) error {
-	vaultSecrets, err := cfg.getVaultSecrets("kv/data/retro-board/api-keys")
+func BuildKeyPepper(cfg *Config) error {
+	if cfg.Local.KeyPepper != "" {
+		return nil
+	}
+
+	pepperData, err := cfg.readSecret(cfg.Local.KeyPepperPath)
 	if err != nil {
 		return err
 	}
 
-	if vaultSecrets == nil {
-		return fmt.Errorf("api keys not found in vault")
+	if err := RequireKeys(cfg.Local.KeyPepperPath, pepperData, "pepper"); err != nil {
+		return err
 	}
 
-	secrets, err := ParseKVSecrets(vaultSecrets)
+	cfg.Local.KeyPepper = pepperData["pepper"]
+	return nil
+}
+
+func BuildServiceKeys(cfg *Config) error {
+	path := cfg.Local.apiKeysPath()
+	keys, err := cfg.readSecret(path)
 	if err != nil {
 		return err
 	}
 
-	for _, secret := range secrets {
-		switch secret.Key {
-		case "retro":
-			cfg.Local.Services.RetroService.Key = secret.Value
-		case "user":
-			cfg.Local.Services.UserService.Key = secret.Value
-		case "company":
-			cfg.Local.Services.CompanyService.Key = secret.Value
-		case "key":
-			cfg.Local.Services.TimerService.Key = secret.Value
-		case "billing":
-			cfg.Local.Services.BillingService.Key = secret.Value
-		case "permission":
-			cfg.Local.Services.PermissionService.Key = secret.Value
+	// a key from the secrets wins, but a service can still get by with one given in env
+	var errs []error
+	for i, service := range cfg.Local.Services {
+		if key, ok := keys[service.vaultName]; ok {
+			cfg.Local.Services[i].Key = key
+			continue
+		}
+		if service.Key == "" {
+			errs = append(errs, &MissingKeyError{Path: path, Key: service.vaultName})
 		}
 	}
 
-	return nil
+	return collectErrors(errs)
 }
@@ -1,45 +1,78 @@
 package config
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"time"
 
-	"github.com/caarlos0/env/v6"
+	vaultAPI "github.com/hashicorp/vault/api"
 )
 
 type Mongo struct {
-	Host     string `env:"MONGO_HOST" envDefault:"localhost"`
-	Username string `env:"MONGO_USER" envDefault:""`
-	Password string `env:"MONGO_PASS" envDefault:""`
+	Host     string `env:"MONGO_HOST" envDefault:"localhost" json:"host,omitempty"`
+	Username string `env:"MONGO_USER" envDefault:"" json:"username,omitempty"`
+	Password string `env:"MONGO_PASS" envDefault:"" json:"password,omitempty"`
+
+	MaxPoolSize            uint64        `env:"MONGO_MAX_POOL_SIZE" envDefault:"100" json:"max_pool_size,omitempty"`
+	MinPoolSize            uint64        `env:"MONGO_MIN_POOL_SIZE" envDefault:"0" json:"min_pool_size,omitempty"`
+	MaxConnIdleTime        time.Duration `env:"MONGO_MAX_CONN_IDLE_TIME" envDefault:"5m" json:"max_conn_idle_time,omitempty"`
+	ConnectTimeout         time.Duration `env:"MONGO_CONNECT_TIMEOUT" envDefault:"10s" json:"connect_timeout,omitempty"`
+	ServerSelectionTimeout time.Duration `env:"MONGO_SERVER_SELECTION_TIMEOUT" envDefault:"5s" json:"server_selection_timeout,omitempty"`
+	RetryWrites            bool          `env:"MONGO_RETRY_WRITES" envDefault:"true" json:"retry_writes,omitempty"`
+	RetryReads             bool          `env:"MONGO_RETRY_READS" envDefault:"true" json:"retry_reads,omitempty"`
+
+	SecretPath string `env:"MONGO_SECRET_PATH" envDefault:"kv/data/retro-board/key-service-mongodb" json:"secret_path,omitempty"`
+
+	// Credentials is static for the user in kv, or vault for one issued by the database engine
+	Credentials   string `env:"MONGO_CREDENTIALS" envDefault:"static" json:"credentials,omitempty"`
+	DatabaseMount string `env:"MONGO_VAULT_MOUNT" envDefault:"database" json:"database_mount,omitempty"`
+	DatabaseRole  string `env:"MONGO_VAULT_ROLE" envDefault:"key-service" json:"database_role,omitempty"`
+
+	// MinRenewInterval is the least time between new credentials, each one means reconnecting mongo
+	MinRenewInterval time.Duration `env:"MONGO_MIN_RENEW_INTERVAL" envDefault:"1m" json:"min_renew_interval,omitempty"`
+
+	lease *vaultAPI.Secret
 }
 
 func BuildMongo(c *Config) error {
+	// only the mongo store needs a database, an empty KEY_STORE is mongo just as it is for the store
+	if c.Local.KeyStore != "mongo" && c.Local.KeyStore != "" {
+		return nil
+	}
+
 	mongo := &Mongo{}
 
-	if err := env.Parse(mongo); err != nil {
+	if err := c.parseEnv(mongo); err != nil {
 		return err
 	}
 
-	creds, err := c.getVaultSecrets("kv/data/retro-board/key-service-mongodb")
+	kvStrings, err := c.readSecret(mongo.SecretPath)
 	if err != nil {
 		return err
 	}
 
-	if creds == nil {
-		return errors.New("no mongo password found")
+	// vault issues the user itself, so only the host has to be in the secret
+	required := []string{"host", "username", "password"}
+	if mongo.Credentials == MongoCredentialsVault {
+		required = required[:1]
 	}
-
-	kvs, err := ParseKVSecrets(creds)
-	if err != nil {
+	if err := RequireKeys(mongo.SecretPath, kvStrings, required...); err != nil {
 		return err
 	}
-	if len(kvs) == 0 {
-		return errors.New("no mongo details found")
-	}
 
-	kvStrings := KVStrings(kvs)
 	mongo.Password = kvStrings["password"]
 	mongo.Username = kvStrings["username"]
 	mongo.Host = kvStrings["host"]
+
+	switch mongo.Credentials {
+	case MongoCredentialsVault:
+		if err := c.readDatabaseCredentials(context.Background(), mongo); err != nil {
+			return err
+		}
+	case MongoCredentialsStatic, "":
+	default:
+		return fmt.Errorf("unknown mongo credentials source: %s", mongo.Credentials)
+	}
 	c.Mongo = *mongo
 
 	return nil
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	vaultAPI "github.com/hashicorp/vault/api"
+)
+
+const (
+	MongoCredentialsStatic = "static"
+	MongoCredentialsVault  = "vault"
+)
+
+// readDatabaseCredentials asks the vault database engine for a fresh mongo user, the lease is kept to renew it
+func (c *Config) readDatabaseCredentials(ctx context.Context, m *Mongo) error {
+	client := c.VaultClient()
+	if client == nil {
+		return fmt.Errorf("vault client not built")
+	}
+
+	secret, err := client.Logical().ReadWithContext(ctx, fmt.Sprintf("%s/creds/%s", m.DatabaseMount, m.DatabaseRole))
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("no mongo credentials issued for role: %s", m.DatabaseRole)
+	}
+
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" || password == "" {
+		return fmt.Errorf("incomplete mongo credentials issued for role: %s", m.DatabaseRole)
+	}
+
+	m.Username = username
+	m.Password = password
+	m.lease = secret
+
+	return nil
+}
+
+// DynamicCredentials is true when the mongo user comes from a vault lease rather than kv
+func (m Mongo) DynamicCredentials() bool {
+	return m.lease != nil
+}
+
+// RenewMongoCredentials keeps the mongo lease alive until ctx is done, when it can't be renewed any more
+// new credentials are issued and handed to rotated so the store can reconnect with them
+func (c *Config) RenewMongoCredentials(ctx context.Context, rotated func(Mongo)) {
+	current := c.CurrentMongo()
+	if current.lease == nil {
+		return
+	}
+
+	client := c.VaultClient()
+	var lastIssued time.Time
+	for {
+		watcher, err := client.NewLifetimeWatcher(&vaultAPI.LifetimeWatcherInput{
+			Secret: current.lease,
+		})
+		if err != nil {
+			bugLog.Info(err)
+			return
+		}
+		go watcher.Start()
+
+		done := watchLease(ctx, watcher, "mongo credentials")
+		watcher.Stop()
+		if !done {
+			return
+		}
+
+		// a role with a very short ttl would otherwise have mongo reconnecting almost continuously
+		if wait := time.Until(lastIssued.Add(current.MinRenewInterval)); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		next := current
+		if !retry(ctx, c.Vault.RetryWait, "mongo credentials", func() error {
+			return c.readDatabaseCredentials(ctx, &next)
+		}) {
+			return
+		}
+		bugLog.Local().Infof("vault issued new mongo credentials for %s", next.DatabaseRole)
+		lastIssued = time.Now()
+
+		current = next
+		c.setMongo(current)
+		rotated(current)
+	}
+}
+
+// CurrentMongo is the mongo config with whichever credentials vault issued last
+func (c *Config) CurrentMongo() Mongo {
+	c.mongoMu.RLock()
+	defer c.mongoMu.RUnlock()
+	return c.Mongo
+}
+
+func (c *Config) setMongo(m Mongo) {
+	c.mongoMu.Lock()
+	defer c.mongoMu.Unlock()
+	c.Mongo = m
+}
@@ -0,0 +1,222 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+// testDatabaseVault issues a new mongo user on every creds read, apart from the failIssue'th which is refused
+func testDatabaseVault(t *testing.T, failIssue int) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	issued := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/kv/data/retro-board/key-service-mongodb", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"data":{"host":"mongo.test","username":"static","password":"static"}}}`))
+	})
+	mux.HandleFunc("/v1/database/creds/key-service", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		issued++
+		n := issued
+		mu.Unlock()
+
+		if n == failIssue {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["database unavailable"]}`))
+			return
+		}
+
+		// a one second lease that can't be renewed, so the watcher asks for new credentials straight away
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"lease_id":"database/creds/key-service/%d","lease_duration":1,"renewable":false,"data":{"username":"user-%d","password":"pass-%d"}}`, n, n, n)
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBuildMongo_Credentials(t *testing.T) {
+	vault := testDatabaseVault(t, 0)
+
+	tests := []struct {
+		name     string
+		source   string
+		username string
+		dynamic  bool
+		wantErr  bool
+	}{
+		{
+			name:     "test_static",
+			source:   config.MongoCredentialsStatic,
+			username: "static",
+		},
+		{
+			name:     "test_vault",
+			source:   config.MongoCredentialsVault,
+			username: "user-1",
+			dynamic:  true,
+		},
+		{
+			name:    "test_unknown_source",
+			source:  "bob",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONGO_CREDENTIALS", tt.source)
+			cfg := &config.Config{
+				Vault: config.Vault{
+					Address: vault.URL,
+				},
+			}
+			if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			err := config.BuildMongo(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildMongo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if cfg.Mongo.Username != tt.username || cfg.Mongo.Host != "mongo.test" {
+				t.Errorf("BuildMongo() = %v@%v, want %v@mongo.test", cfg.Mongo.Username, cfg.Mongo.Host, tt.username)
+			}
+			if cfg.Mongo.DynamicCredentials() != tt.dynamic {
+				t.Errorf("Mongo.DynamicCredentials() = %v, want %v", cfg.Mongo.DynamicCredentials(), tt.dynamic)
+			}
+		})
+	}
+}
+
+func TestConfig_RenewMongoCredentials(t *testing.T) {
+	t.Setenv("MONGO_CREDENTIALS", config.MongoCredentialsVault)
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address: testDatabaseVault(t, 0).URL,
+		},
+	}
+	if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.BuildMongo(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	issued := cfg.CurrentMongo()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rotated := make(chan config.Mongo, 1)
+	go cfg.RenewMongoCredentials(ctx, func(creds config.Mongo) {
+		select {
+		case rotated <- creds:
+		default:
+		}
+	})
+
+	select {
+	case creds := <-rotated:
+		if creds.Username == issued.Username {
+			t.Errorf("RenewMongoCredentials() username = %v, want new credentials", creds.Username)
+		}
+		if creds.Host != issued.Host {
+			t.Errorf("RenewMongoCredentials() host = %v, want %v", creds.Host, issued.Host)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("RenewMongoCredentials() didn't rotate the expiring credentials")
+	}
+}
+
+func TestConfig_RenewMongoCredentials_RetryAfterFailure(t *testing.T) {
+	t.Setenv("MONGO_CREDENTIALS", config.MongoCredentialsVault)
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address:   testDatabaseVault(t, 2).URL,
+			RetryWait: time.Millisecond * 10,
+		},
+	}
+	if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.BuildMongo(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rotated := make(chan config.Mongo, 1)
+	go cfg.RenewMongoCredentials(ctx, func(creds config.Mongo) {
+		select {
+		case rotated <- creds:
+		default:
+		}
+	})
+
+	select {
+	case creds := <-rotated:
+		if creds.Username != "user-3" {
+			t.Errorf("RenewMongoCredentials() username = %v, want user-3", creds.Username)
+		}
+		if current := cfg.CurrentMongo(); current.Username != creds.Username || current.Password != creds.Password {
+			t.Errorf("CurrentMongo() = %v, want the rotated credentials %v", current.Username, creds.Username)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("RenewMongoCredentials() gave up after the failed credentials read")
+	}
+}
+
+func TestConfig_RenewMongoCredentials_MinInterval(t *testing.T) {
+	t.Setenv("MONGO_CREDENTIALS", config.MongoCredentialsVault)
+	t.Setenv("MONGO_MIN_RENEW_INTERVAL", "1h")
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address: testDatabaseVault(t, 0).URL,
+		},
+	}
+	if err := cfg.Vault.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.BuildMongo(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rotated := make(chan config.Mongo, 2)
+	go cfg.RenewMongoCredentials(ctx, func(creds config.Mongo) {
+		rotated <- creds
+	})
+
+	select {
+	case <-rotated:
+	case <-time.After(time.Second * 5):
+		t.Fatal("RenewMongoCredentials() didn't rotate the expiring credentials")
+	}
+
+	// the one second lease runs out again well inside this, but new credentials wait for the interval
+	select {
+	case creds := <-rotated:
+		t.Errorf("RenewMongoCredentials() rotated again to %v, want it to wait for MONGO_MIN_RENEW_INTERVAL", creds.Username)
+	case <-time.After(time.Millisecond * 2500):
+	}
+}
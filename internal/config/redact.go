@@ -0,0 +1,43 @@
+package config
+
+// RedactedValue is what a secret is replaced with when the config is printed
+const RedactedValue = "[redacted]"
+
+// Resolved is the config as it is printed, every section under its own name
+type Resolved struct {
+	Local   Local   `json:"local"`
+	Mongo   Mongo   `json:"mongo"`
+	Vault   Vault   `json:"vault"`
+	Secrets Secrets `json:"secrets"`
+}
+
+// Redacted is a copy of the resolved config that is safe to print, anything secret that was set is replaced
+func (c *Config) Redacted() Resolved {
+	r := Resolved{
+		Local:   c.Local,
+		Mongo:   c.CurrentMongo(),
+		Vault:   c.Vault,
+		Secrets: c.Secrets,
+	}
+
+	redact(&r.Mongo.Password)
+	redact(&r.Vault.Token)
+	redact(&r.Vault.AppRoleSecretID)
+	redact(&r.Local.OnePasswordKey)
+	redact(&r.Local.KeyPepper)
+
+	r.Local.Services = make(Services, len(c.Local.Services))
+	copy(r.Local.Services, c.Local.Services)
+	for i := range r.Local.Services {
+		redact(&r.Local.Services[i].Key)
+	}
+	r.Local.Keyring = nil
+
+	return r
+}
+
+func redact(s *string) {
+	if *s != "" {
+		*s = RedactedValue
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"context"
+	"time"
+
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"github.com/retro-board/key-service/internal/metrics"
+)
+
+const defaultAPIKeysPath = "kv/data/retro-board/api-keys"
+
+func (l Local) apiKeysPath() string {
+	if l.APIKeysPath == "" {
+		return defaultAPIKeysPath
+	}
+
+	return l.APIKeysPath
+}
+
+// AcceptedServiceKeys is what ValidateServiceKey accepts, from the keyring once it has been built
+func (l Local) AcceptedServiceKeys() []ServiceKeys {
+	if l.Keyring != nil {
+		return l.Keyring.Accepted()
+	}
+
+	return []ServiceKeys{l.serviceKeys()}
+}
+
+func (l Local) serviceKeys() ServiceKeys {
+	keys := ServiceKeys{
+		Services: make(map[string]string, len(l.Services)),
+		Shared:   l.OnePasswordKey,
+	}
+	for _, service := range l.Services {
+		keys.Services[service.Name] = service.Key
+	}
+
+	return keys
+}
+
+// ReadServiceKeys reads the service keys again, anything the secrets don't have keeps its current value
+func (c *Config) ReadServiceKeys(ctx context.Context) (ServiceKeys, error) {
+	current := c.Local.serviceKeys()
+	if c.Local.Keyring != nil {
+		current = c.Local.Keyring.Current()
+	}
+
+	next := ServiceKeys{
+		Services: make(map[string]string, len(current.Services)),
+		Shared:   current.Shared,
+	}
+	for name, key := range current.Services {
+		next.Services[name] = key
+	}
+
+	keys, err := c.SecretsProvider().ReadSecret(ctx, c.Local.apiKeysPath())
+	if err != nil {
+		return current, err
+	}
+	for _, service := range c.Local.Services {
+		if key, ok := keys[service.vaultName]; ok {
+			next.Services[service.Name] = key
+		}
+	}
+
+	if c.Local.sharedKeyFromSecrets {
+		shared, err := c.SecretsProvider().ReadSecret(ctx, c.Local.OnePasswordPath)
+		if err != nil {
+			return current, err
+		}
+		if password, ok := shared["password"]; ok {
+			next.Shared = password
+		}
+	}
+
+	return next, nil
+}
+
+// RefreshServiceKeys re-reads the service keys every interval until ctx is done, so a key rotated in vault
+// is picked up without a redeploy
+func (c *Config) RefreshServiceKeys(ctx context.Context) {
+	if c.Local.Keyring == nil || c.Local.ServiceKeyRefresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.Local.ServiceKeyRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshServiceKeys(ctx)
+		}
+	}
+}
+
+func (c *Config) refreshServiceKeys(ctx context.Context) {
+	keys, err := c.ReadServiceKeys(ctx)
+	if err != nil {
+		bugLog.Info(err)
+		metrics.ServiceKeyRefreshes.WithLabelValues("error").Inc()
+		return
+	}
+	metrics.ServiceKeyRefreshes.WithLabelValues("ok").Inc()
+
+	for _, name := range c.Local.Keyring.Rotate(keys) {
+		bugLog.Local().Infof("service key rotated for %s, the old key is accepted for %s", name, c.Local.ServiceKeyOverlap)
+		metrics.ServiceKeyRotations.WithLabelValues(name).Inc()
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SecretsVault = "vault"
+	SecretsEnv   = "env"
+	SecretsFile  = "file"
+)
+
+// SecretsProvider is where the build reads its secrets from, each path is a flat set of key/values
+type SecretsProvider interface {
+	ReadSecret(ctx context.Context, path string) (map[string]string, error)
+}
+
+type Secrets struct {
+	Provider  string `env:"SECRETS_PROVIDER" envDefault:"vault" json:"provider,omitempty"`
+	File      string `env:"SECRETS_FILE" envDefault:"secrets.yaml" json:"file,omitempty"`
+	EnvPrefix string `env:"SECRETS_ENV_PREFIX" envDefault:"SECRETS" json:"env_prefix,omitempty"`
+}
+
+func BuildSecrets(c *Config) error {
+	s := &Secrets{}
+	if err := c.parseEnv(s); err != nil {
+		return err
+	}
+	c.Secrets = *s
+
+	switch s.Provider {
+	case SecretsVault, "":
+		c.secrets = &VaultSecrets{Config: c}
+	case SecretsEnv:
+		c.secrets = &EnvSecrets{Prefix: s.EnvPrefix}
+	case SecretsFile:
+		c.secrets = &FileSecrets{Path: s.File}
+	default:
+		return fmt.Errorf("unknown secrets provider: %s", s.Provider)
+	}
+
+	return nil
+}
+
+// SecretsProvider is vault unless BuildSecrets chose something else
+func (c *Config) SecretsProvider() SecretsProvider {
+	if c.secrets == nil {
+		return &VaultSecrets{Config: c}
+	}
+
+	return c.secrets
+}
+
+func (c *Config) UsesVault() bool {
+	_, ok := c.SecretsProvider().(*VaultSecrets)
+	return ok
+}
+
+func (c *Config) readSecret(path string) (map[string]string, error) {
+	return c.SecretsProvider().ReadSecret(context.Background(), path)
+}
+
+// VaultSecrets reads through the shared vault client
+type VaultSecrets struct {
+	Config *Config
+}
+
+func (v *VaultSecrets) ReadSecret(ctx context.Context, path string) (map[string]string, error) {
+	kv := v.Config.KV()
+	if kv == nil {
+		return nil, fmt.Errorf("vault client not built")
+	}
+
+	return kv.Read(ctx, path)
+}
+
+// EnvSecrets reads <prefix>_<last path segment>_<key>, so kv/data/retro-board/api-keys user is SECRETS_API_KEYS_USER
+type EnvSecrets struct {
+	Prefix string
+}
+
+func envSecretName(prefix, path string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(filepath.Base(path)))
+	if prefix == "" {
+		return name + "_"
+	}
+
+	return prefix + "_" + name + "_"
+}
+
+func (e *EnvSecrets) ReadSecret(ctx context.Context, path string) (map[string]string, error) {
+	prefix := envSecretName(e.Prefix, path)
+
+	secrets := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		secrets[strings.ToLower(strings.TrimPrefix(name, prefix))] = value
+	}
+	if len(secrets) == 0 {
+		return nil, &SecretNotFoundError{Path: prefix + "*"}
+	}
+
+	return secrets, nil
+}
+
+// FileSecrets reads a JSON or YAML file of path to key/values, it is read every time so edits are picked up
+type FileSecrets struct {
+	Path string
+}
+
+func (f *FileSecrets) ReadSecret(ctx context.Context, path string) (map[string]string, error) {
+	contents, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths map[string]map[string]string
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".json":
+		err = json.Unmarshal(contents, &paths)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &paths)
+	default:
+		return nil, fmt.Errorf("unknown secrets file type: %s", f.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, ok := paths[path]
+	if !ok {
+		return nil, &SecretNotFoundError{Path: path}
+	}
+
+	return secrets, nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Service struct {
+	Name    string        `json:"name"`
+	Key     string        `json:"key,omitempty"`
+	Address string        `json:"address,omitempty"`
+	KeyTTL  time.Duration `json:"key_ttl,omitempty"`
+
+	envName   string
+	vaultName string
+}
+
+type Services []Service
+
+// knownServices keeps the env and vault names these services had before the registry
+var knownServices = map[string]Service{
+	"user": {
+		Address:   "https://api.retro-board.it/v1/user",
+		envName:   "USER",
+		vaultName: "user",
+	},
+	"retro": {
+		Address:   "https://api.retro-board.it/v1/retro",
+		envName:   "RETRO",
+		vaultName: "retro",
+	},
+	"timer": {
+		Address:   "https://api.retro-board.it/v1/key",
+		envName:   "TIMER",
+		vaultName: "key",
+	},
+	"company": {
+		Address:   "https://api.retro-board.it/v1/company",
+		envName:   "COMPANY",
+		vaultName: "company",
+	},
+	"billing": {
+		Address:   "https://api.retro-board.it/v1/billing",
+		envName:   "BILLING",
+		vaultName: "billing",
+	},
+	"permissions": {
+		Address:   "https://api.retro-board.it/v1/permission",
+		envName:   "PERMISSION",
+		vaultName: "permission",
+	},
+}
+
+const DefaultServiceNames = "user,retro,timer,company,billing,permissions"
+
+func NewService(name string) Service {
+	s, ok := knownServices[name]
+	if !ok {
+		s = Service{
+			envName:   strings.ToUpper(name),
+			vaultName: name,
+		}
+	}
+	s.Name = name
+
+	return s
+}
+
+func NewServices(names ...string) Services {
+	services := make(Services, 0, len(names))
+	for _, name := range names {
+		services = append(services, NewService(name))
+	}
+
+	return services
+}
+
+func DefaultServices() Services {
+	return NewServices(strings.Split(DefaultServiceNames, ",")...)
+}
+
+func (s Services) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, service := range s {
+		names = append(names, service.Name)
+	}
+
+	return names
+}
+
+func (s Services) Get(name string) (Service, bool) {
+	for _, service := range s {
+		if service.Name == name {
+			return service, true
+		}
+	}
+
+	return Service{}, false
+}
+
+// BuildServices creates the registry from SERVICES, each service can then be set with <NAME>_SERVICE_KEY, _ADDRESS and _KEY_TTL
+func BuildServices(cfg *Config) error {
+	services := make(Services, 0, len(cfg.Local.ServiceNames))
+	for _, name := range cfg.Local.ServiceNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := services.Get(name); ok {
+			return fmt.Errorf("service listed twice: %s", name)
+		}
+
+		s := NewService(name)
+		if key := cfg.getenv(s.envName + "_SERVICE_KEY"); key != "" {
+			s.Key = key
+		}
+		if address := cfg.getenv(s.envName + "_SERVICE_ADDRESS"); address != "" {
+			s.Address = address
+		}
+		if ttl := cfg.getenv(s.envName + "_SERVICE_KEY_TTL"); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil {
+				return fmt.Errorf("%s_SERVICE_KEY_TTL: %w", s.envName, err)
+			}
+			s.KeyTTL = d
+		}
+		services = append(services, s)
+	}
+	cfg.Local.Services = services
+
+	return nil
+}
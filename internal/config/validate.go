@@ -0,0 +1,314 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ValidationError holds every problem Validate found, so a bad deploy can be fixed in one go
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// addError keeps each problem of a ValidationError as its own problem
+func (p *problems) addError(err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		*p = append(*p, validationErr.Problems...)
+		return
+	}
+
+	*p = append(*p, err.Error())
+}
+
+func (p problems) err() error {
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+
+	return nil
+}
+
+// collectErrors is nil, the one error, or a ValidationError holding every one of them
+func collectErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	var p problems
+	for _, err := range errs {
+		p.addError(err)
+	}
+
+	return p.err()
+}
+
+// withContext puts context in front of the error, or in front of each problem when there are several
+func withContext(context string, err error) error {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return fmt.Errorf("%s: %w", context, err)
+	}
+
+	var p problems
+	for _, problem := range validationErr.Problems {
+		p.add("%s: %s", context, problem)
+	}
+
+	return p.err()
+}
+
+// Validate checks the built config, it reports everything that is wrong rather than the first thing
+func (c *Config) Validate() error {
+	var p problems
+
+	c.validateSettings(&p)
+	c.validateSecretValues(&p)
+
+	return p.err()
+}
+
+// validateSettings checks everything that comes from env or the config file, nothing it looks at is read from the secrets
+func (c *Config) validateSettings(p *problems) {
+	c.validateLocal(p)
+	c.validateServices(p)
+	c.validateMongo(p)
+	c.validateVault(p)
+	c.validateSecrets(p)
+}
+
+// validateSecretValues checks what the builders read from the secrets
+func (c *Config) validateSecretValues(p *problems) {
+	if c.Local.KeyPepper == "" {
+		p.add("no key pepper, set KEY_PEPPER or KEY_PEPPER_PATH")
+	}
+	for _, service := range c.Local.Services {
+		if service.Key == "" {
+			p.add("service %s has no key", service.Name)
+		}
+	}
+	if c.Local.KeyStore == "mongo" && c.Mongo.Host == "" {
+		p.add("no mongo host")
+	}
+}
+
+// secretsReadable is false when the settings that say where the secrets are can't be used,
+// reading them would only fail again with the same problem
+func (c *Config) secretsReadable() bool {
+	if !knownSecretsProvider(c.Secrets.Provider) {
+		return false
+	}
+	if !c.usesVaultSettings() {
+		return true
+	}
+
+	u, err := url.ParseRequestURI(c.Vault.Address)
+	return err == nil && u.Host != "" && knownVaultAuth(c.Vault.AuthMethod)
+}
+
+func (c *Config) usesVaultSettings() bool {
+	usesVault := c.Secrets.Provider == SecretsVault || c.Secrets.Provider == ""
+	return usesVault || c.Mongo.Credentials == MongoCredentialsVault
+}
+
+func (c *Config) validateLocal(p *problems) {
+	l := c.Local
+	if !validPort(l.HTTPPort) {
+		p.add("HTTP_PORT %d is not a valid port", l.HTTPPort)
+	}
+	if !validPort(l.GRPCPort) {
+		p.add("GRPC_PORT %d is not a valid port", l.GRPCPort)
+	}
+	if l.HTTPPort == l.GRPCPort {
+		p.add("HTTP_PORT and GRPC_PORT are both %d", l.HTTPPort)
+	}
+
+	if l.ShutdownTimeout <= 0 {
+		p.add("SHUTDOWN_TIMEOUT must be more than 0")
+	}
+	if l.ShutdownDelay < 0 {
+		p.add("SHUTDOWN_DELAY can't be negative")
+	}
+	if l.KeyTTL <= 0 {
+		p.add("KEY_TTL must be more than 0")
+	}
+	if l.RotationGrace < 0 {
+		p.add("KEY_ROTATION_GRACE can't be negative")
+	}
+	if l.KeyRetention < 0 {
+		p.add("KEY_RETENTION can't be negative")
+	}
+
+	switch l.KeyStore {
+	case "mongo", "memory":
+	default:
+		p.add("KEY_STORE %q is not mongo or memory", l.KeyStore)
+	}
+
+	switch l.Tracing.Exporter {
+	case "none", "otlp", "":
+	default:
+		p.add("TRACING_EXPORTER %q is not none or otlp", l.Tracing.Exporter)
+	}
+	if l.Tracing.SampleRatio < 0 || l.Tracing.SampleRatio > 1 {
+		p.add("TRACING_SAMPLE_RATIO %v is not between 0 and 1", l.Tracing.SampleRatio)
+	}
+
+	switch l.Audit.Sink {
+	case "mongo":
+		if l.KeyStore != "mongo" {
+			p.add("AUDIT_SINK mongo needs KEY_STORE mongo")
+		}
+	case "memory", "log", "none", "":
+	default:
+		p.add("AUDIT_SINK %q is not mongo, memory, log or none", l.Audit.Sink)
+	}
+	// only the mongo sink is queued, the others write straight away
+	queued := l.Audit.Sink == "mongo" || (l.Audit.Sink == "" && l.KeyStore == "mongo")
+	if queued && l.Audit.QueueSize < 1 {
+		p.add("AUDIT_QUEUE_SIZE %d must be at least 1", l.Audit.QueueSize)
+	}
+	for _, operation := range l.Audit.Operations {
+		if !knownOperation(operation) || operation == "audit" {
+			p.add("AUDIT_OPERATIONS names %s, which is not an operation", operation)
+		}
+	}
+}
+
+func (c *Config) validateServices(p *problems) {
+	if len(c.Local.Services) == 0 {
+		p.add("SERVICES is empty")
+	}
+
+	for _, service := range c.Local.Services {
+		if service.Address != "" {
+			if _, err := url.ParseRequestURI(service.Address); err != nil {
+				p.add("service %s address %q is not a url", service.Name, service.Address)
+			}
+		}
+		if service.KeyTTL < 0 {
+			p.add("service %s key ttl can't be negative", service.Name)
+		}
+	}
+
+	auth := c.Local.Authorization
+	for operation, names := range map[string][]string{
+		"AUTH_CREATE":   auth.Create,
+		"AUTH_GET":      auth.Get,
+		"AUTH_VALIDATE": auth.Validate,
+		"AUTH_REVOKE":   auth.Revoke,
+		"AUTH_ROTATE":   auth.Rotate,
+		"AUTH_AUDIT":    auth.Audit,
+	} {
+		for _, name := range names {
+			if _, ok := c.Local.Services.Get(name); !ok {
+				p.add("%s names %s, which is not in SERVICES", operation, name)
+			}
+		}
+	}
+	for _, operation := range auth.Shared {
+		if !knownOperation(operation) {
+			p.add("AUTH_SHARED names %s, which is not an operation", operation)
+		}
+	}
+}
+
+func (c *Config) validateMongo(p *problems) {
+	if c.Local.KeyStore != "mongo" {
+		return
+	}
+
+	m := c.Mongo
+	if m.MinPoolSize > m.MaxPoolSize {
+		p.add("MONGO_MIN_POOL_SIZE %d is more than MONGO_MAX_POOL_SIZE %d", m.MinPoolSize, m.MaxPoolSize)
+	}
+
+	if !knownMongoCredentials(m.Credentials) {
+		p.add("MONGO_CREDENTIALS %q is not static or vault", m.Credentials)
+	}
+	if m.MinRenewInterval < 0 {
+		p.add("MONGO_MIN_RENEW_INTERVAL can't be negative")
+	}
+}
+
+func (c *Config) validateVault(p *problems) {
+	if !c.usesVaultSettings() {
+		return
+	}
+
+	v := c.Vault
+	if u, err := url.ParseRequestURI(v.Address); err != nil || u.Host == "" {
+		p.add("VAULT_ADDRESS %q is not a url", v.Address)
+	}
+	if v.Timeout <= 0 {
+		p.add("VAULT_TIMEOUT must be more than 0")
+	}
+	if v.MaxRetries < 0 {
+		p.add("VAULT_MAX_RETRIES can't be negative")
+	}
+
+	if !knownVaultAuth(v.AuthMethod) {
+		p.add("VAULT_AUTH_METHOD %q is not token, kubernetes or approle", v.AuthMethod)
+	}
+}
+
+func (c *Config) validateSecrets(p *problems) {
+	if !knownSecretsProvider(c.Secrets.Provider) {
+		p.add("SECRETS_PROVIDER %q is not vault, env or file", c.Secrets.Provider)
+	}
+}
+
+func knownSecretsProvider(provider string) bool {
+	switch provider {
+	case SecretsVault, SecretsEnv, SecretsFile, "":
+		return true
+	}
+
+	return false
+}
+
+func knownVaultAuth(method string) bool {
+	switch method {
+	case VaultAuthToken, VaultAuthKubernetes, VaultAuthAppRole, "":
+		return true
+	}
+
+	return false
+}
+
+func knownMongoCredentials(credentials string) bool {
+	switch credentials {
+	case MongoCredentialsStatic, MongoCredentialsVault, "":
+		return true
+	}
+
+	return false
+}
+
+func knownOperation(operation string) bool {
+	switch operation {
+	case "create", "get", "validate", "revoke", "rotate", "audit":
+		return true
+	}
+
+	return false
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
@@ -0,0 +1,189 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func validConfig() *config.Config {
+	services := config.NewServices("user", "retro")
+	for i := range services {
+		services[i].Key = services[i].Name + "Key"
+	}
+
+	return &config.Config{
+		Local: config.Local{
+			HTTPPort:        3000,
+			GRPCPort:        8001,
+			ShutdownTimeout: time.Second * 20,
+			KeyStore:        "memory",
+			KeyTTL:          time.Hour * 2,
+			KeyPepper:       "pepper",
+			Services:        services,
+			Authorization: config.Authorization{
+				Create:   []string{"user"},
+				Validate: []string{"user", "retro"},
+			},
+			Tracing: config.Tracing{
+				Exporter:    "none",
+				SampleRatio: 1,
+			},
+		},
+		Secrets: config.Secrets{
+			Provider: config.SecretsFile,
+		},
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(cfg *config.Config)
+		problems int
+	}{
+		{
+			name:   "test_valid",
+			change: func(cfg *config.Config) {},
+		},
+		{
+			name: "test_every_problem",
+			change: func(cfg *config.Config) {
+				cfg.Local.HTTPPort = 0
+				cfg.Local.GRPCPort = 70000
+				cfg.Local.KeyPepper = ""
+				cfg.Local.KeyStore = "redis"
+				cfg.Local.Tracing.SampleRatio = 2
+				cfg.Local.Authorization.Revoke = []string{"audit"}
+				cfg.Local.Services[1].Key = ""
+			},
+			problems: 7,
+		},
+		{
+			name: "test_same_port",
+			change: func(cfg *config.Config) {
+				cfg.Local.GRPCPort = cfg.Local.HTTPPort
+			},
+			problems: 1,
+		},
+		{
+			name: "test_audit_queue",
+			change: func(cfg *config.Config) {
+				cfg.Local.Audit.Sink = "mongo"
+				cfg.Local.Audit.QueueSize = 0
+			},
+			problems: 2,
+		},
+		{
+			name: "test_vault_only_when_used",
+			change: func(cfg *config.Config) {
+				cfg.Vault.Address = "not a url"
+				cfg.Secrets.Provider = config.SecretsVault
+			},
+			problems: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if tt.problems == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+
+			var validationErr *config.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got: %v, want: a ValidationError", err)
+			}
+			if len(validationErr.Problems) != tt.problems {
+				t.Errorf("got: %d problems %v, want: %d", len(validationErr.Problems), validationErr.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.Mongo.Password = "mongoPass"
+	cfg.Vault.Token = "vaultToken"
+	cfg.Local.OnePasswordKey = "sharedKey"
+
+	r := cfg.Redacted()
+	for _, got := range []string{r.Mongo.Password, r.Vault.Token, r.Local.OnePasswordKey, r.Local.KeyPepper, r.Local.Services[0].Key} {
+		if got != config.RedactedValue {
+			t.Errorf("got: %v, want: %v", got, config.RedactedValue)
+		}
+	}
+	if r.Vault.AppRoleSecretID != "" {
+		t.Errorf("got: %v, want: an unset secret left empty", r.Vault.AppRoleSecretID)
+	}
+	if cfg.Local.Services[0].Key != "userKey" {
+		t.Errorf("got: %v, want: the config itself left alone", cfg.Local.Services[0].Key)
+	}
+}
+
+func TestBuild_EveryProblem(t *testing.T) {
+	// retro's key and the pepper are missing from the secrets
+	t.Setenv("SECRETS_PROVIDER", config.SecretsFile)
+	t.Setenv("SECRETS_FILE", secretsFile(t, "secrets.yaml", `
+kv/data/retro-board/key-service-mongodb:
+  host: mongo.test
+  username: tester
+  password: mongoPass
+kv/data/retro-board/api-keys:
+  user: userKey
+kv/data/retro-board/one-password:
+  password: sharedKey
+kv/data/retro-board/key-service-pepper:
+  salt: pepper
+`))
+	t.Setenv("ONE_PASSWORD_PATH", "kv/data/retro-board/one-password")
+	t.Setenv("SERVICES", "user,retro")
+	t.Setenv("AUTH_VALIDATE", "user,retro")
+	t.Setenv("HTTP_PORT", "0")
+	t.Setenv("KEY_STORE", "bogus")
+
+	_, err := config.Build()
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got: %v, want: a ValidationError", err)
+	}
+
+	want := []string{"HTTP_PORT", "KEY_STORE", "has no retro", "has no pepper"}
+	for _, w := range want {
+		found := false
+		for _, problem := range validationErr.Problems {
+			if strings.Contains(problem, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("got: %v, want a problem about %s", validationErr.Problems, w)
+		}
+	}
+}
+
+func TestBuild_SettingsBeforeSecrets(t *testing.T) {
+	// an unknown provider means nothing can be read, the other settings are still reported
+	t.Setenv("SECRETS_PROVIDER", "bogus")
+	t.Setenv("MONGO_CREDENTIALS", "bogus")
+	t.Setenv("GRPC_PORT", "3000")
+
+	_, err := config.Build()
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got: %v, want: a ValidationError", err)
+	}
+	if len(validationErr.Problems) != 3 {
+		t.Errorf("got: %v, want: the provider, credentials and port problems", validationErr.Problems)
+	}
+}
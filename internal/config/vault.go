@@ -1,87 +1,96 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"time"
 
-	"github.com/caarlos0/env/v6"
 	vaultAPI "github.com/hashicorp/vault/api"
 )
 
 type Vault struct {
-	Address string `env:"VAULT_ADDRESS" envDefault:"http://vault.vault:8200"`
-	Token   string `env:"VAULT_TOKEN" envDefault:""`
-}
+	Address    string `env:"VAULT_ADDRESS" envDefault:"http://vault.vault:8200" json:"address,omitempty"`
+	Token      string `env:"VAULT_TOKEN" envDefault:"" json:"token,omitempty"`
+	AuthMethod string `env:"VAULT_AUTH_METHOD" envDefault:"token" json:"auth_method,omitempty"`
 
-type KVSecret struct {
-	Key   string
-	Value string
-}
+	KubernetesRole      string `env:"VAULT_KUBERNETES_ROLE" json:"kubernetes_role,omitempty"`
+	KubernetesMount     string `env:"VAULT_KUBERNETES_MOUNT" envDefault:"kubernetes" json:"kubernetes_mount,omitempty"`
+	KubernetesTokenPath string `env:"VAULT_KUBERNETES_TOKEN_PATH" envDefault:"/var/run/secrets/kubernetes.io/serviceaccount/token" json:"kubernetes_token_path,omitempty"`
 
-type KVSecretData struct {
-	Data map[string]interface{} `json:"data"`
-}
+	AppRoleID           string `env:"VAULT_APPROLE_ROLE_ID" json:"approle_role_id,omitempty"`
+	AppRoleSecretID     string `env:"VAULT_APPROLE_SECRET_ID" json:"approle_secret_id,omitempty"`
+	AppRoleSecretIDFile string `env:"VAULT_APPROLE_SECRET_ID_FILE" json:"approle_secret_id_file,omitempty"`
+	AppRoleMount        string `env:"VAULT_APPROLE_MOUNT" envDefault:"approle" json:"approle_mount,omitempty"`
 
-func GetVaultSecrets(vaultAddress, vaultToken, secretPath string) (map[string]interface{}, error) {
-	var m = make(map[string]interface{})
+	Timeout    time.Duration `env:"VAULT_TIMEOUT" envDefault:"10s" json:"timeout,omitempty"`
+	MaxRetries int           `env:"VAULT_MAX_RETRIES" envDefault:"2" json:"max_retries,omitempty"`
+	RetryWait  time.Duration `env:"VAULT_RETRY_WAIT" envDefault:"1s" json:"retry_wait,omitempty"`
 
+	client *vaultAPI.Client
+	kv     *KVReader
+}
+
+// NewVaultClient builds the client that Config shares, a 5xx or a dropped connection is retried before giving up
+func NewVaultClient(v Vault) (*vaultAPI.Client, error) {
 	cfg := vaultAPI.DefaultConfig()
-	cfg.Address = vaultAddress
-	client, err := vaultAPI.NewClient(cfg)
-	if err != nil {
-		return m, err
+	if cfg.Error != nil {
+		return nil, cfg.Error
 	}
+	cfg.Address = v.Address
+	cfg.Timeout = v.Timeout
+	cfg.MaxRetries = v.MaxRetries
+	// same spread as the vault defaults, 1s to 1.5s
+	cfg.MinRetryWait = v.RetryWait
+	cfg.MaxRetryWait = v.RetryWait + v.RetryWait/2
 
-	client.SetToken(vaultToken)
-
-	data, err := client.Logical().Read(secretPath)
+	client, err := vaultAPI.NewClient(cfg)
 	if err != nil {
-		return m, err
+		return nil, err
 	}
+	client.SetToken(v.Token)
 
-	if data == nil {
-		return m, fmt.Errorf("no data at path: %s", secretPath)
-	}
+	return client, nil
+}
 
-	return data.Data, nil
+// VaultClient is the one client every vault read goes through, nil until BuildVault has run
+func (c *Config) VaultClient() *vaultAPI.Client {
+	return c.Vault.client
 }
 
-func (c *Config) getVaultSecrets(secretPath string) (map[string]interface{}, error) {
-	return GetVaultSecrets(c.Vault.Address, c.Vault.Token, secretPath)
+// KV reads kv secrets with the shared client, nil until BuildVault has run
+func (c *Config) KV() *KVReader {
+	return c.Vault.kv
 }
 
-func BuildVault(c *Config) error {
-	v := &Vault{}
+// VerifyVaultToken fails if vault is sealed or unreachable, or the token has expired or been revoked
+func (c *Config) VerifyVaultToken(ctx context.Context) error {
+	client := c.VaultClient()
+	if client == nil {
+		return fmt.Errorf("vault client not built")
+	}
 
-	if err := env.Parse(v); err != nil {
+	if _, err := client.Auth().Token().LookupSelfWithContext(ctx); err != nil {
 		return err
 	}
 
-	c.Vault = *v
-
 	return nil
 }
 
-func ParseKVSecrets(data map[string]interface{}) ([]KVSecret, error) {
-	var secrets []KVSecret
-
-	for ik, iv := range data {
-		if ik == "data" {
-			for k, v := range iv.(map[string]interface{}) {
-				secrets = append(secrets, KVSecret{
-					Key:   k,
-					Value: fmt.Sprintf("%v", v),
-				})
-			}
+func BuildVault(c *Config) error {
+	v := &Vault{}
+
+	if err := c.parseEnv(v); err != nil {
+		return err
+	}
+
+	// nothing to log in to when the secrets come from somewhere else
+	if c.UsesVault() || c.Mongo.Credentials == MongoCredentialsVault {
+		if err := v.Authenticate(context.Background()); err != nil {
+			return fmt.Errorf("vault %s auth: %w", v.AuthMethod, err)
 		}
 	}
 
-	return secrets, nil
-}
+	c.Vault = *v
 
-func KVStrings(kvs []KVSecret) map[string]string {
-	results := make(map[string]string)
-	for _, kv := range kvs {
-		results[kv.Key] = kv.Value
-	}
-	return results
+	return nil
 }
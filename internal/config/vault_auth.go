@@ -0,0 +1,191 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/hashicorp/vault/api/auth/kubernetes"
+)
+
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthAppRole    = "approle"
+)
+
+// maxRetryWait caps the backoff between logins, so the service recovers soon after vault is back
+const maxRetryWait = time.Minute
+
+// CurrentToken is whatever the shared client is using, which changes when the lifetime watcher logs in again
+func (v Vault) CurrentToken() string {
+	if v.client == nil {
+		return v.Token
+	}
+
+	return v.client.Token()
+}
+
+func (v Vault) authMethod() (vaultAPI.AuthMethod, error) {
+	switch v.AuthMethod {
+	case VaultAuthKubernetes:
+		if v.KubernetesRole == "" {
+			return nil, fmt.Errorf("VAULT_KUBERNETES_ROLE is needed for kubernetes auth")
+		}
+		return kubernetes.NewKubernetesAuth(
+			v.KubernetesRole,
+			kubernetes.WithMountPath(v.KubernetesMount),
+			kubernetes.WithServiceAccountTokenPath(v.KubernetesTokenPath),
+		)
+	case VaultAuthAppRole:
+		if v.AppRoleID == "" {
+			return nil, fmt.Errorf("VAULT_APPROLE_ROLE_ID is needed for approle auth")
+		}
+		secretID := &approle.SecretID{FromString: v.AppRoleSecretID}
+		if v.AppRoleSecretIDFile != "" {
+			secretID = &approle.SecretID{FromFile: v.AppRoleSecretIDFile}
+		}
+		return approle.NewAppRoleAuth(v.AppRoleID, secretID, approle.WithMountPath(v.AppRoleMount))
+	}
+
+	return nil, fmt.Errorf("unknown vault auth method: %s", v.AuthMethod)
+}
+
+// login gets a token for the configured auth method, a static token is looked up so it can be renewed if it allows it
+func (v Vault) login(ctx context.Context, client *vaultAPI.Client) (*vaultAPI.Secret, error) {
+	if v.AuthMethod == VaultAuthToken || v.AuthMethod == "" {
+		client.SetToken(v.Token)
+		self, err := client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if renewable, _ := self.TokenIsRenewable(); !renewable {
+			return nil, nil
+		}
+		return client.Auth().Token().RenewSelfWithContext(ctx, 0)
+	}
+
+	method, err := v.authMethod()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.Auth().Login(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("no token returned by %s login", v.AuthMethod)
+	}
+
+	return secret, nil
+}
+
+// Authenticate builds the shared client, logs it in, and keeps the token alive in the background,
+// renewing it until it can't and then logging in again
+func (v *Vault) Authenticate(ctx context.Context) error {
+	client, err := NewVaultClient(*v)
+	if err != nil {
+		return err
+	}
+
+	v.client = client
+	v.kv = NewKVReader(client)
+	if (v.AuthMethod == VaultAuthToken || v.AuthMethod == "") && v.Token == "" {
+		return nil
+	}
+
+	secret, err := v.login(ctx, client)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return nil
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+	go v.watchToken(ctx, client, secret)
+
+	return nil
+}
+
+func (v Vault) watchToken(ctx context.Context, client *vaultAPI.Client, secret *vaultAPI.Secret) {
+	for {
+		watcher, err := client.NewLifetimeWatcher(&vaultAPI.LifetimeWatcherInput{
+			Secret: secret,
+		})
+		if err != nil {
+			bugLog.Info(err)
+			return
+		}
+		go watcher.Start()
+
+		if !watchLease(ctx, watcher, "vault token") {
+			watcher.Stop()
+			return
+		}
+		watcher.Stop()
+
+		// a static token can't be replaced, so once it is done there is nothing more to do
+		if v.AuthMethod == VaultAuthToken || v.AuthMethod == "" {
+			bugLog.Local().Info("vault token can no longer be renewed")
+			return
+		}
+
+		// vault being away for a moment mustn't end renewal for good, so the login is tried until it works
+		if !retry(ctx, v.RetryWait, "vault login", func() error {
+			secret, err = v.login(ctx, client)
+			return err
+		}) {
+			return
+		}
+		client.SetToken(secret.Auth.ClientToken)
+		bugLog.Local().Infof("logged in to vault again with %s auth", v.AuthMethod)
+	}
+}
+
+// retry calls fn until it works, waiting twice as long after each failure up to maxRetryWait,
+// it returns false if ctx is done first
+func retry(ctx context.Context, wait time.Duration, what string, fn func() error) bool {
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	for {
+		err := fn()
+		if err == nil {
+			return true
+		}
+		bugLog.Local().Infof("%s failed, trying again in %s: %v", what, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// watchLease returns true when the watcher has finished and a new lease is needed, false when ctx is done
+func watchLease(ctx context.Context, watcher *vaultAPI.LifetimeWatcher, what string) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				bugLog.Local().Infof("%s renewal stopped: %v", what, err)
+			}
+			return true
+		case renewal := <-watcher.RenewCh():
+			bugLog.Local().Infof("%s renewed at %s", what, renewal.RenewedAt)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func testVault(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	login := func(token string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"` + token + `","renewable":false,"lease_duration":3600}}`))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/auth/approle/login", login("approle-token"))
+	mux.HandleFunc("/v1/auth/kubernetes/login", login("kubernetes-token"))
+	mux.HandleFunc("/v1/auth/token/lookup-self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "static-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"renewable":false,"ttl":0}}`))
+	})
+
+	reads := 0
+	mux.HandleFunc("/v1/kv/data/retro-board/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		// the first read fails so the client has to retry it
+		reads++
+		if reads == 1 {
+			http.Error(w, `{"errors":["sealed"]}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"data":{"user":"userKey"}}}`))
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestVault_Authenticate(t *testing.T) {
+	vault := testVault(t)
+
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		vault   config.Vault
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "test_static_token",
+			vault: config.Vault{
+				AuthMethod: config.VaultAuthToken,
+				Token:      "static-token",
+			},
+			want: "static-token",
+		},
+		{
+			name: "test_bad_static_token",
+			vault: config.Vault{
+				AuthMethod: config.VaultAuthToken,
+				Token:      "bob",
+			},
+			wantErr: true,
+		},
+		{
+			name: "test_approle",
+			vault: config.Vault{
+				AuthMethod:      config.VaultAuthAppRole,
+				AppRoleID:       "key-service",
+				AppRoleSecretID: "secret",
+				AppRoleMount:    "approle",
+			},
+			want: "approle-token",
+		},
+		{
+			name: "test_kubernetes",
+			vault: config.Vault{
+				AuthMethod:          config.VaultAuthKubernetes,
+				KubernetesRole:      "key-service",
+				KubernetesMount:     "kubernetes",
+				KubernetesTokenPath: jwtPath,
+			},
+			want: "kubernetes-token",
+		},
+		{
+			name: "test_kubernetes_missing_role",
+			vault: config.Vault{
+				AuthMethod: config.VaultAuthKubernetes,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test_unknown_method",
+			vault: config.Vault{
+				AuthMethod: "bob",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			v := tt.vault
+			v.Address = vault.URL
+			err := v.Authenticate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Vault.Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && v.CurrentToken() != tt.want {
+				t.Errorf("Vault.CurrentToken() = %v, want %v", v.CurrentToken(), tt.want)
+			}
+		})
+	}
+}
+
+func TestVault_Authenticate_LoginAgainAfterFailure(t *testing.T) {
+	var mu sync.Mutex
+	logins := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", vaultNotFound)
+	mux.HandleFunc("/v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		logins++
+		login := logins
+		mu.Unlock()
+
+		// the first login to replace the expired token fails, like vault being restarted
+		if login == 2 {
+			http.Error(w, `{"errors":["vault is sealed"]}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"auth":{"client_token":"approle-token-%d","renewable":false,"lease_duration":1}}`, login)
+	})
+	vault := httptest.NewServer(mux)
+	defer vault.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v := config.Vault{
+		Address:         vault.URL,
+		AuthMethod:      config.VaultAuthAppRole,
+		AppRoleID:       "key-service",
+		AppRoleSecretID: "secret",
+		AppRoleMount:    "approle",
+		Timeout:         time.Second,
+		RetryWait:       time.Millisecond * 10,
+	}
+	if err := v.Authenticate(ctx); err != nil {
+		t.Fatalf("Vault.Authenticate() error = %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second * 10)
+	for v.CurrentToken() != "approle-token-3" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if v.CurrentToken() != "approle-token-3" {
+		t.Errorf("Vault.CurrentToken() = %v, want the token from the login after the failed one", v.CurrentToken())
+	}
+}
@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+func TestConfig_VerifyVaultToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &config.Config{
+		Vault: config.Vault{
+			Address:    testVault(t).URL,
+			Token:      "static-token",
+			AuthMethod: config.VaultAuthToken,
+			Timeout:    time.Second,
+			MaxRetries: 2,
+			RetryWait:  time.Millisecond * 10,
+		},
+	}
+	if err := cfg.VerifyVaultToken(ctx); err == nil {
+		t.Errorf("Config.VerifyVaultToken() error = nil, want an error before the client is built")
+	}
+
+	if err := cfg.Vault.Authenticate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client := cfg.VaultClient()
+
+	for i := 0; i < 2; i++ {
+		if err := cfg.VerifyVaultToken(ctx); err != nil {
+			t.Errorf("Config.VerifyVaultToken() error = %v", err)
+		}
+	}
+	if cfg.VaultClient() != client {
+		t.Errorf("Config.VaultClient() changed between calls")
+	}
+}
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusShutdown = "shutting_down"
+)
+
+type Check func(ctx context.Context) error
+
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type Report struct {
+	Status    string            `json:"status"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Checks    map[string]Result `json:"checks,omitempty"`
+}
+
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs the dependency checks for readiness, results are reused for the ttl so probes don't hammer mongo and vault
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+	checks  map[string]Check
+
+	mu     sync.Mutex
+	report *Report
+}
+
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	return &Checker{
+		ttl:     ttl,
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+	c.report = nil
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return *c.report
+	}
+
+	report := c.run(ctx)
+	c.report = &report
+	return report
+}
+
+// run checks every dependency at once, so a slow one doesn't push the others past the probe timeout
+func (c *Checker) run(ctx context.Context) Report {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	report := Report{
+		Status:    StatusOK,
+		CheckedAt: time.Now(),
+		Checks:    make(map[string]Result, len(c.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			start := time.Now()
+			result := Result{
+				Status: StatusOK,
+			}
+			if err := check(ctx); err != nil {
+				result.Status = StatusFailing
+				result.Error = err.Error()
+			}
+			result.Duration = time.Since(start).String()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFailing
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, Report{
+		Status:    StatusOK,
+		CheckedAt: time.Now(),
+	})
+}
+
+func (c *Checker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+	if !report.Healthy() {
+		jsonResponse(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, report)
+}
+
+func jsonResponse(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
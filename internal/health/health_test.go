@@ -0,0 +1,88 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/health"
+)
+
+func TestChecker_ReadyHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		storeErr error
+		want     int
+		status   string
+	}{
+		{
+			name:   "test_ready",
+			want:   http.StatusOK,
+			status: health.StatusOK,
+		},
+		{
+			name:     "test_store_failing",
+			storeErr: errors.New("auth failed"),
+			want:     http.StatusServiceUnavailable,
+			status:   health.StatusFailing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := health.NewChecker(time.Minute, time.Second)
+			c.Add("key_store", func(ctx context.Context) error { return tt.storeErr })
+			c.Add("vault", func(ctx context.Context) error { return nil })
+
+			rec := httptest.NewRecorder()
+			c.ReadyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.want {
+				t.Errorf("ReadyHandler() = %v, want %v", rec.Code, tt.want)
+			}
+
+			var report health.Report
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.status {
+				t.Errorf("ReadyHandler() status = %v, want %v", report.Status, tt.status)
+			}
+			if report.Checks["key_store"].Status != tt.status {
+				t.Errorf("ReadyHandler() key_store = %v, want %v", report.Checks["key_store"], tt.status)
+			}
+			if report.Checks["vault"].Status != health.StatusOK {
+				t.Errorf("ReadyHandler() vault = %v, want %v", report.Checks["vault"], health.StatusOK)
+			}
+		})
+	}
+}
+
+func TestChecker_Cache(t *testing.T) {
+	calls := 0
+	c := health.NewChecker(time.Minute, time.Second)
+	c.Add("key_store", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	c.Check(context.Background())
+	c.Check(context.Background())
+	if calls != 1 {
+		t.Errorf("Checker.Check() ran %v times, want 1 within the ttl", calls)
+	}
+
+	uncached := health.NewChecker(0, time.Second)
+	uncached.Add("key_store", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	uncached.Check(context.Background())
+	uncached.Check(context.Background())
+	if calls != 3 {
+		t.Errorf("Checker.Check() ran %v times, want 3 without a ttl", calls)
+	}
+}
@@ -0,0 +1,313 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/retro-board/key-service/internal/audit"
+	"github.com/retro-board/key-service/internal/config"
+	pb "github.com/retro-board/protos/generated/key/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+	TransportCLI  = "cli"
+)
+
+// RequestIDMetadata is the grpc equivalent of the X-Request-Id header chi's RequestID uses
+const RequestIDMetadata = "x-request-id"
+
+// how long an event gets to be written, it doesn't use the request context so a client hanging up can't lose it
+const auditTimeout = time.Second * 5
+
+// auditEntry is filled in by Authorize while the request is handled, the transport writes the event afterwards
+type auditEntry struct {
+	caller string
+	denied *Error
+}
+
+type auditContextKey struct{}
+
+func withAuditEntry(ctx context.Context) (context.Context, *auditEntry) {
+	entry := &auditEntry{}
+	return context.WithValue(ctx, auditContextKey{}, entry), entry
+}
+
+func auditCaller(ctx context.Context, caller string) {
+	if entry, ok := ctx.Value(auditContextKey{}).(*auditEntry); ok {
+		entry.caller = caller
+	}
+}
+
+func auditDenied(ctx context.Context, caller string, e *Error) {
+	if entry, ok := ctx.Value(auditContextKey{}).(*auditEntry); ok {
+		entry.caller = caller
+		entry.denied = e
+	}
+}
+
+// NewAuditSink builds the sink AUDIT_SINK asks for, without one the events go to the same place as the keys
+func NewAuditSink(ctx context.Context, cfg *config.Config, store KeyStore) (audit.Sink, error) {
+	sink := cfg.Local.Audit.Sink
+	if sink == "" {
+		sink = cfg.Local.KeyStore
+	}
+
+	switch sink {
+	case audit.SinkMemory:
+		return audit.NewMemory(), nil
+	case audit.SinkLog:
+		return audit.NewLog(os.Stdout), nil
+	case audit.SinkNone:
+		return audit.Nop{}, nil
+	case audit.SinkMongo, "":
+		if i, ok := store.(*instrumentedStore); ok {
+			store = i.store
+		}
+		m, ok := store.(*Mongo)
+		if !ok {
+			return nil, errors.New("the mongo audit sink needs the mongo key store")
+		}
+		sink, err := audit.NewMongo(ctx, m.Client)
+		if err != nil {
+			return nil, err
+		}
+		// inserts can be slow, so they are queued rather than made inside the request
+		return audit.NewAsync(sink, cfg.Local.Audit.QueueSize, auditTimeout), nil
+	}
+
+	return nil, errors.New("unknown audit sink: " + sink)
+}
+
+func auditing(cfg *config.Config, operation string) bool {
+	return listed(cfg.Local.Audit.Operations, operation)
+}
+
+// Record writes the event, a failure is logged rather than failing the operation it describes
+func (k *Key) Record(event audit.Event) {
+	record(k.Audit, event)
+}
+
+func record(sink audit.Sink, event audit.Event) {
+	if sink == nil {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	if err := sink.Record(ctx, event); err != nil {
+		bugLog.Info(err)
+	}
+}
+
+// audited records an event for every request the handler serves
+func (k Key) audited(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auditing(k.Config, operation) {
+			next(w, r)
+			return
+		}
+
+		ctx, entry := withAuditEntry(r.Context())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next(ww, r.WithContext(ctx))
+
+		service := chi.URLParam(r, "service")
+		if service == "" {
+			service = r.URL.Query().Get("service")
+		}
+		event := audit.Event{
+			RequestID: middleware.GetReqID(r.Context()),
+			Transport: TransportHTTP,
+			Caller:    entry.caller,
+			Operation: operation,
+			UserID:    r.Header.Get("X-User-ID"),
+			Service:   service,
+			Outcome:   audit.OutcomeSuccess,
+		}
+		switch {
+		case entry.denied != nil:
+			event.Outcome = audit.OutcomeDenied
+			event.Reason = entry.denied.Reason
+		case ww.Status() >= http.StatusBadRequest:
+			event.Outcome = audit.OutcomeFailure
+			event.Reason = strings.ToLower(http.StatusText(ww.Status()))
+		}
+
+		record(k.Audit, event)
+	}
+}
+
+type userRequest interface {
+	GetUserId() string
+}
+
+// AuditInterceptor records an event for every key service call, the health checks aren't audited
+func AuditInterceptor(cfg *config.Config, sink audit.Sink) grpc.UnaryServerInterceptor {
+	prefix := "/" + pb.KeyService_ServiceDesc.ServiceName + "/"
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		operation := strings.ToLower(path.Base(info.FullMethod))
+		if !strings.HasPrefix(info.FullMethod, prefix) || !auditing(cfg, operation) {
+			return handler(ctx, req)
+		}
+
+		ctx, entry := withAuditEntry(ctx)
+		res, err := handler(ctx, req)
+
+		event := audit.Event{
+			RequestID: incomingMetadata(ctx, RequestIDMetadata),
+			Transport: TransportGRPC,
+			Caller:    entry.caller,
+			Operation: operation,
+			Service:   targetService(ctx),
+			Outcome:   audit.OutcomeSuccess,
+		}
+		if r, ok := req.(userRequest); ok {
+			event.UserID = r.GetUserId()
+		}
+		event.Outcome, event.Reason = grpcOutcome(entry, res, err)
+
+		record(sink, event)
+		return res, err
+	}
+}
+
+// grpcOutcome works out how the call went, legacy clients get their failures in a Status field rather than an error
+func grpcOutcome(entry *auditEntry, res interface{}, err error) (string, string) {
+	if entry.denied != nil {
+		return audit.OutcomeDenied, entry.denied.Reason
+	}
+
+	var keyErr *Error
+	switch {
+	case errors.As(err, &keyErr):
+		return audit.OutcomeFailure, keyErr.Reason
+	case err != nil:
+		return audit.OutcomeFailure, strings.ToLower(status.Code(err).String())
+	}
+
+	switch r := res.(type) {
+	case *pb.KeyResponse:
+		if r.Status != "" && r.Status != "ok" {
+			return audit.OutcomeFailure, r.Status
+		}
+	case *pb.ValidResponse:
+		if !r.Valid {
+			reason := "invalid"
+			if r.Status != nil {
+				reason = *r.Status
+			}
+			return audit.OutcomeFailure, reason
+		}
+	}
+
+	return audit.OutcomeSuccess, ""
+}
+
+// incomingMetadata is the first value the caller sent for name
+func incomingMetadata(ctx context.Context, name string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(name); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+type AuditResponse struct {
+	Status string        `json:"status"`
+	Events []audit.Event `json:"events,omitempty"`
+}
+
+// AuditRoutes is the admin api for reading the audit trail
+func (k Key) AuditRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", k.AuditHandler)
+
+	return r
+}
+
+// AuditHandler returns the newest events first, filtered by ?user_id=, ?from= and ?to= (RFC3339) and ?limit=
+func (k Key) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	r, ok := k.authorize(w, r, OperationAudit)
+	if !ok {
+		return
+	}
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, &AuditResponse{
+			Status: err.Error(),
+		})
+		return
+	}
+
+	events, err := k.Audit.Query(r.Context(), filter)
+	if errors.Is(err, audit.ErrNotQueryable) {
+		jsonResponse(w, http.StatusNotImplemented, &AuditResponse{
+			Status: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		bugLog.Info(err)
+		jsonResponse(w, http.StatusInternalServerError, &AuditResponse{
+			Status: "internal error",
+		})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, &AuditResponse{
+		Status: "ok",
+		Events: events,
+	})
+}
+
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		UserID: q.Get("user_id"),
+	}
+
+	var err error
+	if from := q.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, errors.New("from is not an RFC3339 time")
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, errors.New("to is not an RFC3339 time")
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			return filter, errors.New("limit is not a positive number")
+		}
+	}
+
+	return filter, nil
+}
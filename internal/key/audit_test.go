@@ -0,0 +1,248 @@
+package key_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/retro-board/key-service/internal/audit"
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+	pb "github.com/retro-board/protos/generated/key/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func auditConfig() *config.Config {
+	cfg := testConfig()
+	cfg.Local.Authorization.Audit = []string{key.ServiceUser}
+	cfg.Local.Audit.Operations = []string{key.OperationCreate, key.OperationValidate}
+
+	return cfg
+}
+
+func queryEvents(t *testing.T, sink audit.Sink) []audit.Event {
+	t.Helper()
+
+	events, err := sink.Query(context.Background(), audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	return events
+}
+
+func TestKey_AuditHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceKey string
+		outcome    string
+		caller     string
+	}{
+		{
+			name:       "test_success",
+			serviceKey: testServiceKey,
+			outcome:    audit.OutcomeSuccess,
+			caller:     key.ServiceUser,
+		},
+		{
+			name:       "test_denied",
+			serviceKey: "bob",
+			outcome:    audit.OutcomeDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := audit.NewMemory()
+			k := key.NewKey(auditConfig(), key.NewMemory())
+			k.Audit = sink
+			h := middleware.RequestID(k.Routes())
+
+			doRequest(t, h, http.MethodPost, "/", map[string]string{
+				"X-User-ID":     "tester",
+				"X-Service-Key": tt.serviceKey,
+			})
+
+			events := queryEvents(t, sink)
+			if len(events) != 1 {
+				t.Fatalf("got: %d events, want: 1", len(events))
+			}
+			e := events[0]
+			if e.Operation != key.OperationCreate || e.UserID != "tester" || e.Transport != key.TransportHTTP {
+				t.Errorf("got: %+v, want: a create for tester over http", e)
+			}
+			if e.Outcome != tt.outcome || e.Caller != tt.caller {
+				t.Errorf("got: %v %v, want: %v %v", e.Outcome, e.Caller, tt.outcome, tt.caller)
+			}
+			if e.RequestID == "" {
+				t.Errorf("got: no request id, want the one from middleware.RequestID")
+			}
+		})
+	}
+}
+
+func TestKey_AuditOperations(t *testing.T) {
+	sink := audit.NewMemory()
+	k := key.NewKey(auditConfig(), key.NewMemory())
+	k.Audit = sink
+
+	// get isn't in the audited operations
+	doRequest(t, k.Routes(), http.MethodGet, "/", map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	})
+
+	if events := queryEvents(t, sink); len(events) != 0 {
+		t.Errorf("got: %v, want: no events", events)
+	}
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		legacy  bool
+		req     *pb.ValidateRequest
+		outcome string
+		reason  string
+	}{
+		{
+			name:    "test_denied",
+			req:     &pb.ValidateRequest{UserId: "tester", ServiceKey: "bob", CheckKey: "key"},
+			outcome: audit.OutcomeDenied,
+			reason:  "INVALID_SERVICE_KEY",
+		},
+		{
+			name:    "test_unknown_user",
+			req:     &pb.ValidateRequest{UserId: "tester", ServiceKey: testServiceKey, CheckKey: "key"},
+			outcome: audit.OutcomeFailure,
+			reason:  "invalid",
+		},
+		{
+			name:    "test_legacy_missing_check_key",
+			legacy:  true,
+			req:     &pb.ValidateRequest{UserId: "tester", ServiceKey: testServiceKey},
+			outcome: audit.OutcomeFailure,
+			reason:  key.MissingCheckKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := auditConfig()
+			cfg.Local.LegacyStatus = tt.legacy
+			s := &key.Server{Config: cfg, Store: key.NewMemory()}
+			sink := audit.NewMemory()
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+				key.RequestIDMetadata, "request-1",
+				key.TargetServiceMetadata, key.ServiceRetro,
+			))
+			info := &grpc.UnaryServerInfo{FullMethod: "/" + pb.KeyService_ServiceDesc.ServiceName + "/Validate"}
+			_, _ = key.AuditInterceptor(cfg, sink)(ctx, tt.req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Validate(ctx, req.(*pb.ValidateRequest))
+			})
+
+			events := queryEvents(t, sink)
+			if len(events) != 1 {
+				t.Fatalf("got: %d events, want: 1", len(events))
+			}
+			e := events[0]
+			if e.Operation != key.OperationValidate || e.UserID != "tester" || e.Service != key.ServiceRetro || e.RequestID != "request-1" {
+				t.Errorf("got: %+v, want: a validate of tester's retro key for request-1", e)
+			}
+			if e.Outcome != tt.outcome || e.Reason != tt.reason {
+				t.Errorf("got: %v %v, want: %v %v", e.Outcome, e.Reason, tt.outcome, tt.reason)
+			}
+		})
+	}
+}
+
+func TestKey_AuditHandler(t *testing.T) {
+	sink := audit.NewMemory()
+	k := key.NewKey(auditConfig(), key.NewMemory())
+	k.Audit = sink
+	for _, userID := range []string{"tester", "bob"} {
+		k.Record(audit.Event{Operation: key.OperationCreate, UserID: userID, Outcome: audit.OutcomeSuccess})
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		serviceKey string
+		want       int
+		events     int
+	}{
+		{
+			name:       "test_user",
+			query:      "?user_id=tester",
+			serviceKey: testServiceKey,
+			want:       http.StatusOK,
+			events:     1,
+		},
+		{
+			name:       "test_all",
+			serviceKey: testServiceKey,
+			want:       http.StatusOK,
+			events:     2,
+		},
+		{
+			name:       "test_bad_time",
+			query:      "?from=yesterday",
+			serviceKey: testServiceKey,
+			want:       http.StatusBadRequest,
+		},
+		{
+			name:       "test_invalid_service_key",
+			serviceKey: "bob",
+			want:       http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			req.Header.Set("X-Service-Key", tt.serviceKey)
+			rec := httptest.NewRecorder()
+			k.AuditRoutes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("GET /v1/audit = %v, want %v", rec.Code, tt.want)
+			}
+
+			var res key.AuditResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(res.Events) != tt.events {
+				t.Errorf("got: %d events, want: %d", len(res.Events), tt.events)
+			}
+		})
+	}
+}
+
+func TestKey_AuditHTTP_ValidateCaller(t *testing.T) {
+	sink := audit.NewMemory()
+	k := key.NewKey(auditConfig(), key.NewMemory())
+	k.Audit = sink
+	headers := map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	}
+
+	_, created := doRequest(t, k.Routes(), http.MethodPost, "/", headers)
+	doRequest(t, k.Routes(), http.MethodGet, "/"+created.Keys[key.ServiceRetro]+"/validate", headers)
+
+	events := queryEvents(t, sink)
+	if len(events) != 2 {
+		t.Fatalf("got: %d events, want: 2", len(events))
+	}
+	for _, e := range events {
+		if e.Operation == key.OperationValidate && (e.Caller != key.ServiceUser || e.Outcome != audit.OutcomeSuccess) {
+			t.Errorf("got: %v %v, want: %v %v", e.Caller, e.Outcome, key.ServiceUser, audit.OutcomeSuccess)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package key
+
+import (
+	"context"
+)
+
+// CallerShared is the identity given to anything still using the one shared service key
+const CallerShared = "shared"
+
+const (
+	OperationCreate   = "create"
+	OperationGet      = "get"
+	OperationValidate = "validate"
+	OperationRevoke   = "revoke"
+	OperationRotate   = "rotate"
+	OperationAudit    = "audit"
+)
+
+type callerContextKey struct{}
+
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerContextKey{}, caller)
+}
+
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerContextKey{}).(string)
+	return caller
+}
+
+// ValidateServiceKey works out which service is calling from the key it presented,
+// keys replaced by a rotation in vault are still accepted until their overlap runs out
+func (k *Key) ValidateServiceKey(key string) (string, bool) {
+	name := ""
+	shared := false
+	for _, accepted := range k.Config.Local.AcceptedServiceKeys() {
+		for _, service := range k.Config.Local.Services.Names() {
+			serviceKey := accepted.Services[service]
+			if serviceKey != "" && SecureCompare(serviceKey, key) && name == "" {
+				name = service
+			}
+		}
+
+		if accepted.Shared != "" && SecureCompare(accepted.Shared, key) {
+			shared = true
+		}
+	}
+
+	if name == "" && shared && k.Config.Local.AllowSharedServiceKey {
+		name = CallerShared
+	}
+
+	return name, name != ""
+}
+
+func (k *Key) Authorized(caller, operation string) bool {
+	// the shared key could be any service, so it gets its own list of operations rather than a place in each
+	if caller == CallerShared {
+		return k.Config.Local.AllowSharedServiceKey && listed(k.Config.Local.Authorization.Shared, operation)
+	}
+
+	var allowed []string
+	switch operation {
+	case OperationCreate:
+		allowed = k.Config.Local.Authorization.Create
+	case OperationGet:
+		allowed = k.Config.Local.Authorization.Get
+	case OperationValidate:
+		allowed = k.Config.Local.Authorization.Validate
+	case OperationRevoke:
+		allowed = k.Config.Local.Authorization.Revoke
+	case OperationRotate:
+		allowed = k.Config.Local.Authorization.Rotate
+	case OperationAudit:
+		allowed = k.Config.Local.Authorization.Audit
+	}
+
+	return listed(allowed, caller)
+}
+
+func listed(list []string, name string) bool {
+	for _, l := range list {
+		if l == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize checks the service key and records who is calling in the context
+func (k *Key) Authorize(ctx context.Context, serviceKey, operation string) (context.Context, *Error) {
+	if serviceKey == "" {
+		auditDenied(ctx, "", ErrMissingServiceKey)
+		return ctx, ErrMissingServiceKey
+	}
+
+	name, ok := k.ValidateServiceKey(serviceKey)
+	if !ok {
+		auditDenied(ctx, "", ErrInvalidServiceKey)
+		return ctx, ErrInvalidServiceKey
+	}
+
+	if !k.Authorized(name, operation) {
+		auditDenied(ctx, name, ErrCallerNotAllowed)
+		return ctx, ErrCallerNotAllowed
+	}
+
+	auditCaller(ctx, name)
+	return WithCaller(ctx, name), nil
+}
@@ -0,0 +1,71 @@
+package key
+
+import (
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ErrorDomain = "key.retro-board.it"
+
+// Error carries the grpc code, a machine readable reason, and the legacy status string older clients read
+type Error struct {
+	Code   codes.Code
+	Reason string
+	Status string
+}
+
+func (e *Error) Error() string {
+	return e.Status
+}
+
+func (e *Error) GRPCStatus() *status.Status {
+	s := status.New(e.Code, e.Status)
+	ds, err := s.WithDetails(&errdetails.ErrorInfo{
+		Reason: e.Reason,
+		Domain: ErrorDomain,
+	})
+	if err != nil {
+		return s
+	}
+
+	return ds
+}
+
+func (e *Error) HTTPStatus() int {
+	switch e.Code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+var (
+	ErrMissingUserID     = &Error{Code: codes.InvalidArgument, Reason: "MISSING_USER_ID", Status: MissingUserID}
+	ErrMissingServiceKey = &Error{Code: codes.Unauthenticated, Reason: "MISSING_SERVICE_KEY", Status: MissingServiceKey}
+	ErrInvalidServiceKey = &Error{Code: codes.Unauthenticated, Reason: "INVALID_SERVICE_KEY", Status: InvalidServiceKey}
+	ErrMissingCheckKey   = &Error{Code: codes.InvalidArgument, Reason: "MISSING_CHECK_KEY", Status: MissingCheckKey}
+	ErrUserNotFound      = &Error{Code: codes.NotFound, Reason: "USER_NOT_FOUND", Status: UserNotFound}
+	ErrKeysExpired       = &Error{Code: codes.FailedPrecondition, Reason: "KEYS_EXPIRED", Status: KeysExpired}
+	ErrUnknownService    = &Error{Code: codes.InvalidArgument, Reason: "UNKNOWN_SERVICE", Status: UnknownService}
+	ErrCallerNotAllowed  = &Error{Code: codes.PermissionDenied, Reason: "CALLER_NOT_ALLOWED", Status: CallerNotAllowed}
+)
+
+const reasonInternal = "INTERNAL"
+
+func internalError(status string) *Error {
+	return &Error{
+		Code:   codes.Internal,
+		Reason: reasonInternal,
+		Status: status,
+	}
+}
@@ -2,138 +2,131 @@ package key
 
 import (
 	"context"
-	"time"
 
 	"github.com/hashicorp/vault/sdk/helper/pointerutil"
 
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/metrics"
 	pb "github.com/retro-board/protos/generated/key/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
+// Server is the key.v1 grpc service, key.v1 has no revoke or rotate rpcs so keys are only revoked
+// and rotated over http
 type Server struct {
 	pb.UnimplementedKeyServiceServer
 	Config *config.Config
+	Store  KeyStore
 }
 
 const MissingUserID = "missing user-id"
 const MissingServiceKey = "missing service-key"
 const InvalidServiceKey = "invalid service key"
+const MissingCheckKey = "missing check-key"
+const UserNotFound = "user not found"
+const KeysExpired = "expired"
+const UnknownService = "unknown service"
+const CallerNotAllowed = "caller not allowed"
+const KeysRevoked = "revoked"
+
+// the validate request has no field for these yet, so the target and match travel as metadata
+const TargetServiceMetadata = "x-target-service"
+const MatchedServiceMetadata = "x-matched-service"
+
+func targetService(c context.Context) string {
+	return incomingMetadata(c, TargetServiceMetadata)
+}
 
-func (s *Server) Create(c context.Context, r *pb.CreateRequest) (*pb.KeyResponse, error) {
-	if r.UserId == "" {
-		bugLog.Info(MissingUserID)
-		return &pb.KeyResponse{
-			Status: MissingUserID,
-		}, nil
+// keyResponse can only carry the services key.v1 has fields for
+func keyResponse(keys *ResponseItem) *pb.KeyResponse {
+	return &pb.KeyResponse{
+		User:        keys.Keys[ServiceUser],
+		Retro:       keys.Keys[ServiceRetro],
+		Timer:       keys.Keys[ServiceTimer],
+		Company:     keys.Keys[ServiceCompany],
+		Billing:     keys.Keys[ServiceBilling],
+		Permissions: keys.Keys[ServicePermissions],
 	}
+}
 
-	if r.ServiceKey == "" {
-		bugLog.Info(MissingServiceKey)
+// keyError returns the failure as a grpc status, or in the Status field while clients still expect that
+func (s *Server) keyError(e *Error) (*pb.KeyResponse, error) {
+	if s.Config.Local.LegacyStatus {
 		return &pb.KeyResponse{
-			Status: MissingServiceKey,
+			Status: e.Status,
 		}, nil
 	}
 
-	k := NewKey(s.Config)
-	if !k.ValidateServiceKey(r.ServiceKey) {
-		bugLog.Info(InvalidServiceKey)
-		return &pb.KeyResponse{
-			Status: InvalidServiceKey,
+	return nil, e
+}
+
+func (s *Server) validError(e *Error) (*pb.ValidResponse, error) {
+	metrics.ValidationFailed(e.Reason)
+	if s.Config.Local.LegacyStatus {
+		return &pb.ValidResponse{
+			Valid:  false,
+			Status: pointerutil.StringPtr(e.Status),
 		}, nil
 	}
 
-	keys, err := k.GetKeys(25)
-	if err != nil {
-		bugLog.Info(err)
-		status := "internal error, 1"
-		return &pb.KeyResponse{
-			Status: status,
-		}, nil
+	return nil, e
+}
+
+func (s *Server) Create(c context.Context, r *pb.CreateRequest) (*pb.KeyResponse, error) {
+	if r.UserId == "" {
+		bugLog.Info(MissingUserID)
+		return s.keyError(ErrMissingUserID)
 	}
 
-	if err := NewMongo(k.Config).Create(DataSet{
-		UserID:    r.UserId,
-		Generated: time.Now().Unix(),
-		Keys: struct {
-			UserService        string `json:"user_service" bson:"user_service"`
-			RetroService       string `json:"retro_service" bson:"retro_service"`
-			TimerService       string `json:"timer_service" bson:"timer_service"`
-			CompanyService     string `json:"company_service" bson:"company_service"`
-			BillingService     string `json:"billing_service" bson:"billing_service"`
-			PermissionsService string `json:"permissions_service" bson:"permissions_service"`
-		}{
-			UserService:        keys.User,
-			RetroService:       keys.Retro,
-			TimerService:       keys.Timer,
-			CompanyService:     keys.Company,
-			BillingService:     keys.Billing,
-			PermissionsService: keys.Permissions,
-		},
-	}); err != nil {
-		bugLog.Info(err)
-		status := "internal error, 2"
-		return &pb.KeyResponse{
-			Status: status,
-		}, nil
+	k := NewKey(s.Config, s.Store)
+	c, authErr := k.Authorize(c, r.ServiceKey, OperationCreate)
+	if authErr != nil {
+		bugLog.Info(authErr.Status)
+		return s.keyError(authErr)
 	}
 
-	return &pb.KeyResponse{
-		User:    keys.User,
-		Retro:   keys.Retro,
-		Timer:   keys.Timer,
-		Company: keys.Company,
-		Billing: keys.Billing,
-	}, nil
+	keys, createErr := k.CreateKeys(c, r.UserId)
+	if createErr != nil {
+		return s.keyError(createErr)
+	}
+
+	return keyResponse(keys), nil
 }
 
 func (s *Server) Get(c context.Context, r *pb.GetRequest) (*pb.KeyResponse, error) {
 	if r.UserId == "" {
 		bugLog.Info(MissingUserID)
-		return &pb.KeyResponse{
-			Status: MissingUserID,
-		}, nil
-	}
-
-	if r.ServiceKey == "" {
-		bugLog.Info(MissingServiceKey)
-		return &pb.KeyResponse{
-			Status: MissingServiceKey,
-		}, nil
+		return s.keyError(ErrMissingUserID)
 	}
 
-	k := NewKey(s.Config)
-	if !k.ValidateServiceKey(r.ServiceKey) {
-		bugLog.Info(InvalidServiceKey)
-		return &pb.KeyResponse{
-			Status: InvalidServiceKey,
-		}, nil
+	k := NewKey(s.Config, s.Store)
+	c, authErr := k.Authorize(c, r.ServiceKey, OperationGet)
+	if authErr != nil {
+		bugLog.Info(authErr.Status)
+		return s.keyError(authErr)
 	}
 
-	keys, err := NewMongo(k.Config).Get(r.UserId)
+	keys, err := s.Store.Get(c, r.UserId)
 	if err != nil {
 		bugLog.Info(err)
-		status := "internal error, 3"
-		return &pb.KeyResponse{
-			Status: status,
-		}, nil
+		return s.keyError(internalError("internal error, 3"))
 	}
 
 	if keys == nil {
-		status := "user not found"
-		bugLog.Info("no keys or expired for user")
-		return &pb.KeyResponse{
-			Status: status,
-		}, nil
+		bugLog.Info("no keys for user")
+		return s.keyError(ErrUserNotFound)
+	}
+
+	if keys.Expired() {
+		bugLog.Info("keys expired for user")
+		return s.keyError(ErrKeysExpired)
 	}
 
+	// only hashes are stored, so the keys themselves can't be handed back out
 	return &pb.KeyResponse{
-		User:        keys.Keys.UserService,
-		Retro:       keys.Keys.RetroService,
-		Timer:       keys.Keys.TimerService,
-		Company:     keys.Keys.CompanyService,
-		Billing:     keys.Keys.BillingService,
-		Permissions: keys.Keys.PermissionsService,
+		Status: "ok",
 	}, nil
 }
 
@@ -141,63 +134,78 @@ func (s *Server) Get(c context.Context, r *pb.GetRequest) (*pb.KeyResponse, erro
 func (s *Server) Validate(c context.Context, r *pb.ValidateRequest) (*pb.ValidResponse, error) {
 	if r.UserId == "" {
 		bugLog.Info(MissingUserID)
-		return &pb.ValidResponse{
-			Status: pointerutil.StringPtr(MissingUserID),
-		}, nil
+		return s.validError(ErrMissingUserID)
 	}
 
-	if r.ServiceKey == "" {
-		bugLog.Info(MissingServiceKey)
-		return &pb.ValidResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if r.CheckKey == "" {
+		bugLog.Info(MissingCheckKey)
+		return s.validError(ErrMissingCheckKey)
 	}
 
-	if r.CheckKey == "" {
-		status := "missing check-key"
-		bugLog.Info(status)
-		return &pb.ValidResponse{
-			Status: &status,
-		}, nil
+	// the caller is checked first, so only an allowed caller can learn which services exist
+	k := NewKey(s.Config, s.Store)
+	c, authErr := k.Authorize(c, r.ServiceKey, OperationValidate)
+	if authErr != nil {
+		bugLog.Info(authErr.Status)
+		return s.validError(authErr)
 	}
 
-	k := NewKey(s.Config)
-	if !k.ValidateServiceKey(r.ServiceKey) {
-		bugLog.Info(InvalidServiceKey)
-		return &pb.ValidResponse{
-			Valid:  false,
-			Status: pointerutil.StringPtr(InvalidServiceKey),
-		}, nil
+	target := targetService(c)
+	if target != "" && !k.ValidService(target) {
+		bugLog.Info(UnknownService)
+		return s.validError(ErrUnknownService)
 	}
 
 	if s.Config.Local.Development {
+		metrics.ValidationSucceeded(reasonDevelopment)
 		return &pb.ValidResponse{
 			Valid: true,
 		}, nil
 	}
 
-	keys, err := NewMongo(k.Config).Get(r.UserId)
+	keys, err := s.Store.Get(c, r.UserId)
 	if err != nil {
-		status := "internal error, 4"
 		bugLog.Info(err)
+		return s.validError(internalError("internal error, 4"))
+	}
+
+	if keys == nil {
+		metrics.ValidationFailed(reasonUnknownUser)
+		return &pb.ValidResponse{
+			Valid: false,
+		}, nil
+	}
+
+	matched := k.MatchKey(keys, r.CheckKey, target)
+	if matched == nil {
+		metrics.ValidationFailed(reasonNoMatch)
+		return &pb.ValidResponse{
+			Valid: false,
+		}, nil
+	}
+
+	if matched.Revoked {
+		metrics.ValidationFailed(KeysRevoked)
 		return &pb.ValidResponse{
 			Valid:  false,
-			Status: &status,
+			Status: pointerutil.StringPtr(KeysRevoked),
 		}, nil
 	}
 
-	if r.CheckKey == keys.Keys.UserService ||
-		r.CheckKey == keys.Keys.RetroService ||
-		r.CheckKey == keys.Keys.TimerService ||
-		r.CheckKey == keys.Keys.CompanyService ||
-		r.CheckKey == keys.Keys.BillingService ||
-		r.CheckKey == keys.Keys.PermissionsService {
+	if matched.Expired() {
+		metrics.ValidationFailed(KeysExpired)
 		return &pb.ValidResponse{
-			Valid: true,
+			Valid:  false,
+			Status: pointerutil.StringPtr(KeysExpired),
 		}, nil
 	}
 
+	if err := grpc.SetHeader(c, metadata.Pairs(MatchedServiceMetadata, matched.Service)); err != nil {
+		bugLog.Info(err)
+	}
+
+	metrics.ValidationSucceeded(reasonMatched)
 	return &pb.ValidResponse{
-		Valid: false,
+		Valid: true,
 	}, nil
 }
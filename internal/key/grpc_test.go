@@ -0,0 +1,268 @@
+package key_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/key"
+	pb "github.com/retro-board/protos/generated/key/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testServer(legacy bool) *key.Server {
+	cfg := testConfig()
+	cfg.Local.LegacyStatus = legacy
+
+	return &key.Server{
+		Config: cfg,
+		Store:  key.NewMemory(),
+	}
+}
+
+func TestServer_GetErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    *pb.GetRequest
+		code   codes.Code
+		reason string
+	}{
+		{
+			name:   "test_missing_user",
+			req:    &pb.GetRequest{ServiceKey: testServiceKey},
+			code:   codes.InvalidArgument,
+			reason: "MISSING_USER_ID",
+		},
+		{
+			name:   "test_missing_service_key",
+			req:    &pb.GetRequest{UserId: "tester"},
+			code:   codes.Unauthenticated,
+			reason: "MISSING_SERVICE_KEY",
+		},
+		{
+			name:   "test_invalid_service_key",
+			req:    &pb.GetRequest{UserId: "tester", ServiceKey: "bob"},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_SERVICE_KEY",
+		},
+		{
+			name:   "test_user_not_found",
+			req:    &pb.GetRequest{UserId: "tester", ServiceKey: testServiceKey},
+			code:   codes.NotFound,
+			reason: "USER_NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testServer(false).Get(context.Background(), tt.req)
+			s := status.Convert(err)
+			if s.Code() != tt.code {
+				t.Errorf("Server.Get() code = %v, want %v", s.Code(), tt.code)
+			}
+
+			var reason string
+			for _, d := range s.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					reason = info.Reason
+				}
+			}
+			if reason != tt.reason {
+				t.Errorf("Server.Get() reason = %v, want %v", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestServer_LegacyStatus(t *testing.T) {
+	res, err := testServer(true).Get(context.Background(), &pb.GetRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+	})
+	if err != nil {
+		t.Errorf("Server.Get() error = %v, want nil", err)
+	}
+	if res.Status != key.UserNotFound {
+		t.Errorf("Server.Get() status = %v, want %v", res.Status, key.UserNotFound)
+	}
+
+	valid, err := testServer(true).Validate(context.Background(), &pb.ValidateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+	})
+	if err != nil {
+		t.Errorf("Server.Validate() error = %v, want nil", err)
+	}
+	if valid.Status == nil || *valid.Status != key.MissingCheckKey {
+		t.Errorf("Server.Validate() status = %v, want %v", valid.Status, key.MissingCheckKey)
+	}
+}
+
+func TestServer_Validate(t *testing.T) {
+	ctx := context.Background()
+	s := testServer(false)
+
+	created, err := s.Create(ctx, &pb.CreateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		serviceKey string
+		checkKey   string
+		want       bool
+		code       codes.Code
+	}{
+		{
+			name:       "test_valid_key",
+			serviceKey: testServiceKey,
+			checkKey:   created.Timer,
+			want:       true,
+		},
+		{
+			name:       "test_invalid_key",
+			serviceKey: testServiceKey,
+			checkKey:   created.Timer + "1",
+			want:       false,
+		},
+		{
+			name:       "test_invalid_service_key",
+			serviceKey: testServiceKey + "1",
+			checkKey:   created.Timer,
+			code:       codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.Validate(ctx, &pb.ValidateRequest{
+				UserId:     "tester",
+				ServiceKey: tt.serviceKey,
+				CheckKey:   tt.checkKey,
+			})
+			if status.Code(err) != tt.code {
+				t.Fatalf("Server.Validate() code = %v, want %v", status.Code(err), tt.code)
+			}
+			if err == nil && res.Valid != tt.want {
+				t.Errorf("Server.Validate() = %v, want %v", res.Valid, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ValidateRevoked(t *testing.T) {
+	ctx := context.Background()
+	s := testServer(false)
+
+	created, err := s.Create(ctx, &pb.CreateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// revoking is http only, so it is done straight through the key
+	if revokeErr := key.NewKey(s.Config, s.Store).RevokeKeys(ctx, "tester", []string{key.ServiceBilling}); revokeErr != nil {
+		t.Fatal(revokeErr)
+	}
+
+	res, err := s.Validate(ctx, &pb.ValidateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+		CheckKey:   created.Billing,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Status == nil || *res.Status != key.KeysRevoked {
+		t.Errorf("Server.Validate() = %v, want revoked", res)
+	}
+}
+
+// TestServer_Expired is what the retention window on the mongo ttl index is there for, an expired
+// key set that hasn't been deleted yet is reported as expired rather than not found
+func TestServer_Expired(t *testing.T) {
+	ctx := context.Background()
+	s := testServer(false)
+
+	created, err := s.Create(ctx, &pb.CreateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Store.Get(ctx, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	data.ExpiresAt = expired
+	for service := range data.Expiry {
+		data.Expiry[service] = expired.Unix()
+	}
+	if err := s.Store.Upsert(ctx, *data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(ctx, &pb.GetRequest{UserId: "tester", ServiceKey: testServiceKey}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Server.Get() code = %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+
+	res, err := s.Validate(ctx, &pb.ValidateRequest{
+		UserId:     "tester",
+		ServiceKey: testServiceKey,
+		CheckKey:   created.Billing,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Status == nil || *res.Status != key.KeysExpired {
+		t.Errorf("Server.Validate() = %v, want expired", res)
+	}
+}
+
+func TestServer_Validate_UnknownTarget(t *testing.T) {
+	s := testServer(false)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(key.TargetServiceMetadata, "bob"))
+
+	tests := []struct {
+		name       string
+		serviceKey string
+		code       codes.Code
+	}{
+		{
+			// an unauthenticated caller can't tell whether the service exists
+			name:       "test_unauthenticated",
+			serviceKey: "bob",
+			code:       codes.Unauthenticated,
+		},
+		{
+			name:       "test_authenticated",
+			serviceKey: testServiceKey,
+			code:       codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Validate(ctx, &pb.ValidateRequest{
+				UserId:     "tester",
+				ServiceKey: tt.serviceKey,
+				CheckKey:   "check",
+			})
+			if status.Code(err) != tt.code {
+				t.Errorf("Server.Validate() code = %v, want %v", status.Code(err), tt.code)
+			}
+		})
+	}
+}
@@ -3,21 +3,76 @@ package key
 import (
 	"encoding/json"
 	"net/http"
-	"time"
+	"strings"
 
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/go-chi/chi/v5"
+	"github.com/retro-board/key-service/internal/metrics"
 )
 
 type ResponseItem struct {
-	Status string `json:"status"`
-
-	User        string `json:"user_service,omitempty"`
-	Retro       string `json:"retro_service,omitempty"`
-	Timer       string `json:"timer_service,omitempty"`
-	Company     string `json:"company_service,omitempty"`
-	Billing     string `json:"billing_service,omitempty"`
-	Permissions string `json:"permissions,omitempty"`
+	Status  string
+	Service string
+
+	// Keys are the plaintext keys by service, they go out as <service>_service fields
+	Keys map[string]string
+}
+
+// permissions went out without the suffix before the registry, so clients still expect that
+var legacyResponseNames = map[string]string{
+	ServicePermissions: "permissions",
+}
+
+func responseName(service string) string {
+	if name, ok := legacyResponseNames[service]; ok {
+		return name
+	}
+
+	return service + storedSuffix
+}
+
+func (i ResponseItem) MarshalJSON() ([]byte, error) {
+	fields := map[string]string{
+		"status": i.Status,
+	}
+	if i.Service != "" {
+		fields["service"] = i.Service
+	}
+	for service, key := range i.Keys {
+		if key != "" {
+			fields[responseName(service)] = key
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+func (i *ResponseItem) UnmarshalJSON(data []byte) error {
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	i.Status = fields["status"]
+	i.Service = fields["service"]
+	delete(fields, "status")
+	delete(fields, "service")
+
+	legacy := make(map[string]string, len(legacyResponseNames))
+	for service, name := range legacyResponseNames {
+		legacy[name] = service
+	}
+
+	i.Keys = make(map[string]string, len(fields))
+	for name, key := range fields {
+		if service, ok := legacy[name]; ok {
+			i.Keys[service] = key
+			continue
+		}
+		i.Keys[strings.TrimSuffix(name, storedSuffix)] = key
+	}
+
+	return nil
 }
 
 func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
@@ -29,6 +84,31 @@ func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
 	}
 }
 
+func (k Key) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/", k.audited(OperationCreate, k.CreateHandler))
+	r.Post("/rotate", k.audited(OperationRotate, k.RotateHandler))
+	r.Get("/", k.audited(OperationGet, k.GetHandler))
+	r.Get("/{key}/validate", k.audited(OperationValidate, k.ValidateHandler))
+	r.Delete("/", k.audited(OperationRevoke, k.RevokeAllHandler))
+	r.Delete("/services/{service}", k.audited(OperationRevoke, k.RevokeHandler))
+
+	return r
+}
+
+// authorize writes the failure response itself, the handler should stop when it isn't ok
+func (k Key) authorize(w http.ResponseWriter, r *http.Request, operation string) (*http.Request, bool) {
+	ctx, authErr := k.Authorize(r.Context(), r.Header.Get("X-Service-Key"), operation)
+	if authErr != nil {
+		jsonResponse(w, authErr.HTTPStatus(), &ResponseItem{
+			Status: authErr.Status,
+		})
+		return r, false
+	}
+
+	return r.WithContext(ctx), true
+}
+
 func (k Key) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("X-User-ID")
 	if userID == "" {
@@ -38,49 +118,40 @@ func (k Key) CreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if vaultKey := r.Header.Get("X-Service-Key"); vaultKey == "" {
-		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
-			Status: "missing vault-key",
+	r, ok := k.authorize(w, r, OperationCreate)
+	if !ok {
+		return
+	}
+
+	keys, createErr := k.CreateKeys(r.Context(), userID)
+	if createErr != nil {
+		jsonResponse(w, createErr.HTTPStatus(), &ResponseItem{
+			Status: createErr.Status,
 		})
 		return
-	} else if !k.ValidateServiceKey(vaultKey) {
+	}
+
+	jsonResponse(w, http.StatusOK, keys)
+}
+
+func (k Key) RotateHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
 		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
-			Status: "invalid service key",
+			Status: "missing user-id",
 		})
 		return
 	}
 
-	keys, err := k.GetKeys(25)
-	if err != nil {
-		bugLog.Info(err)
-		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
-			Status: "internal error",
-		})
+	r, ok := k.authorize(w, r, OperationRotate)
+	if !ok {
 		return
 	}
 
-	if err := NewMongo(k.Config).Create(DataSet{
-		UserID:    userID,
-		Generated: time.Now().Unix(),
-		Keys: struct {
-			UserService        string `json:"user_service" bson:"user_service"`
-			RetroService       string `json:"retro_service" bson:"retro_service"`
-			TimerService       string `json:"timer_service" bson:"timer_service"`
-			CompanyService     string `json:"company_service" bson:"company_service"`
-			BillingService     string `json:"billing_service" bson:"billing_service"`
-			PermissionsService string `json:"permissions_service" bson:"permissions_service"`
-		}{
-			UserService:        keys.User,
-			RetroService:       keys.Retro,
-			TimerService:       keys.Timer,
-			CompanyService:     keys.Company,
-			BillingService:     keys.Billing,
-			PermissionsService: keys.Permissions,
-		},
-	}); err != nil {
-		bugLog.Info(err)
-		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
-			Status: "internal error",
+	keys, rotateErr := k.RotateKeys(r.Context(), userID)
+	if rotateErr != nil {
+		jsonResponse(w, rotateErr.HTTPStatus(), &ResponseItem{
+			Status: rotateErr.Status,
 		})
 		return
 	}
@@ -97,19 +168,12 @@ func (k Key) GetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if vaultKey := r.Header.Get("X-Service-Key"); vaultKey == "" {
-		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
-			Status: "missing vault-key",
-		})
-		return
-	} else if !k.ValidateServiceKey(vaultKey) {
-		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
-			Status: "invalid service key",
-		})
+	r, ok := k.authorize(w, r, OperationGet)
+	if !ok {
 		return
 	}
 
-	keys, err := NewMongo(k.Config).Get(userID)
+	keys, err := k.Store.Get(r.Context(), userID)
 	if err != nil {
 		bugLog.Info(err)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
@@ -125,14 +189,16 @@ func (k Key) GetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if keys.Expired() {
+		jsonResponse(w, http.StatusGone, &ResponseItem{
+			Status: KeysExpired,
+		})
+		return
+	}
+
+	// only hashes are stored, so the keys themselves can't be handed back out
 	jsonResponse(w, http.StatusOK, &ResponseItem{
-		Status:      "ok",
-		User:        keys.Keys.UserService,
-		Retro:       keys.Keys.RetroService,
-		Timer:       keys.Keys.TimerService,
-		Company:     keys.Keys.CompanyService,
-		Billing:     keys.Keys.BillingService,
-		Permissions: keys.Keys.PermissionsService,
+		Status: "ok",
 	})
 }
 
@@ -140,6 +206,7 @@ func (k Key) GetHandler(w http.ResponseWriter, r *http.Request) {
 func (k Key) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("x-user-id")
 	if userID == "" {
+		metrics.ValidationFailed(ErrMissingUserID.Reason)
 		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
 			Status: "missing user-id",
 		})
@@ -148,15 +215,37 @@ func (k Key) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 
 	checkKey := chi.URLParam(r, "key")
 	if checkKey == "" {
+		metrics.ValidationFailed(ErrMissingCheckKey.Reason)
 		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
 			Status: "missing key",
 		})
 		return
 	}
 
-	keys, err := NewMongo(k.Config).Get(userID)
+	// the caller is checked first, so only an allowed caller can learn which services exist
+	ctx, authErr := k.Authorize(r.Context(), r.Header.Get("X-Service-Key"), OperationValidate)
+	if authErr != nil {
+		metrics.ValidationFailed(authErr.Reason)
+		jsonResponse(w, authErr.HTTPStatus(), &ResponseItem{
+			Status: authErr.Status,
+		})
+		return
+	}
+	r = r.WithContext(ctx)
+
+	service := r.URL.Query().Get("service")
+	if service != "" && !k.ValidService(service) {
+		metrics.ValidationFailed(ErrUnknownService.Reason)
+		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
+			Status: UnknownService,
+		})
+		return
+	}
+
+	keys, err := k.Store.Get(r.Context(), userID)
 	if err != nil {
 		bugLog.Info(err)
+		metrics.ValidationFailed(reasonInternal)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
 			Status: "internal error",
 		})
@@ -164,32 +253,83 @@ func (k Key) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if keys == nil {
+		metrics.ValidationFailed(reasonUnknownUser)
 		jsonResponse(w, http.StatusUnauthorized, &ResponseItem{
 			Status: "not allowed",
 		})
 		return
 	}
 
-	userKey := keys.Keys.UserService
-	retroKey := keys.Keys.RetroService
-	timerKey := keys.Keys.TimerService
-	companyKey := keys.Keys.CompanyService
-	billingKey := keys.Keys.BillingService
-	permissionsKey := keys.Keys.PermissionsService
+	matched := k.MatchKey(keys, checkKey, service)
+	if matched != nil && matched.Revoked {
+		metrics.ValidationFailed(KeysRevoked)
+		jsonResponse(w, http.StatusUnauthorized, &ResponseItem{
+			Status: KeysRevoked,
+		})
+		return
+	}
 
-	if checkKey == userKey ||
-		checkKey == retroKey ||
-		checkKey == timerKey ||
-		checkKey == companyKey ||
-		checkKey == billingKey ||
-		checkKey == permissionsKey {
+	if matched != nil && matched.Expired() {
+		metrics.ValidationFailed(KeysExpired)
+		jsonResponse(w, http.StatusUnauthorized, &ResponseItem{
+			Status: KeysExpired,
+		})
+		return
+	}
+
+	if matched != nil {
+		metrics.ValidationSucceeded(reasonMatched)
 		jsonResponse(w, http.StatusOK, &ResponseItem{
-			Status: "ok",
+			Status:  "ok",
+			Service: matched.Service,
 		})
 		return
 	}
 
+	metrics.ValidationFailed(reasonNoMatch)
 	jsonResponse(w, http.StatusUnauthorized, &ResponseItem{
 		Status: "not allowed",
 	})
 }
+
+func (k Key) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	service := chi.URLParam(r, "service")
+	if !k.ValidService(service) {
+		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
+			Status: UnknownService,
+		})
+		return
+	}
+
+	k.revoke(w, r, []string{service})
+}
+
+func (k Key) RevokeAllHandler(w http.ResponseWriter, r *http.Request) {
+	k.revoke(w, r, k.Config.Local.Services.Names())
+}
+
+func (k Key) revoke(w http.ResponseWriter, r *http.Request, services []string) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		jsonResponse(w, http.StatusBadRequest, &ResponseItem{
+			Status: "missing user-id",
+		})
+		return
+	}
+
+	r, ok := k.authorize(w, r, OperationRevoke)
+	if !ok {
+		return
+	}
+
+	if revokeErr := k.RevokeKeys(r.Context(), userID, services); revokeErr != nil {
+		jsonResponse(w, revokeErr.HTTPStatus(), &ResponseItem{
+			Status: revokeErr.Status,
+		})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, &ResponseItem{
+		Status: "ok",
+	})
+}
@@ -0,0 +1,353 @@
+package key_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+)
+
+const testServiceKey = "tester"
+const testPepper = "pepper"
+
+func testConfig() *config.Config {
+	cfg := &config.Config{
+		Local: config.Local{
+			KeyPepper: testPepper,
+			Authorization: config.Authorization{
+				Create:   []string{key.ServiceUser},
+				Get:      []string{key.ServiceUser},
+				Validate: []string{key.ServiceUser},
+				Revoke:   []string{key.ServiceUser},
+			},
+		},
+	}
+	cfg.Local.Services = withKey(config.DefaultServices(), key.ServiceUser, testServiceKey)
+
+	return cfg
+}
+
+func withKey(services config.Services, name, serviceKey string) config.Services {
+	for i := range services {
+		if services[i].Name == name {
+			services[i].Key = serviceKey
+		}
+	}
+
+	return services
+}
+
+func testRouter() http.Handler {
+	return key.NewKey(testConfig(), key.NewMemory()).Routes()
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path string, headers map[string]string) (int, key.ResponseItem) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var item key.ResponseItem
+	if err := json.NewDecoder(rec.Body).Decode(&item); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	return rec.Code, item
+}
+
+func TestKey_CreateHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{
+			name: "test_create",
+			headers: map[string]string{
+				"X-User-ID":     "tester",
+				"X-Service-Key": testServiceKey,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "test_missing_user",
+			headers: map[string]string{
+				"X-Service-Key": testServiceKey,
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "test_invalid_service_key",
+			headers: map[string]string{
+				"X-User-ID":     "tester",
+				"X-Service-Key": "bob",
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, item := doRequest(t, testRouter(), http.MethodPost, "/", tt.headers)
+			if code != tt.want {
+				t.Errorf("POST /v1/keys = %v, want %v", code, tt.want)
+			}
+			if code == http.StatusOK && len(item.Keys) != len(config.DefaultServices()) {
+				t.Errorf("POST /v1/keys = %v, want keys", item)
+			}
+		})
+	}
+}
+
+func TestKey_GetHandler(t *testing.T) {
+	h := testRouter()
+	headers := map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	}
+
+	if code, _ := doRequest(t, h, http.MethodGet, "/", headers); code != http.StatusNotFound {
+		t.Errorf("GET /v1/keys = %v, want %v", code, http.StatusNotFound)
+	}
+
+	doRequest(t, h, http.MethodPost, "/", headers)
+	code, item := doRequest(t, h, http.MethodGet, "/", headers)
+	if code != http.StatusOK {
+		t.Errorf("GET /v1/keys = %v, want %v", code, http.StatusOK)
+	}
+	if len(item.Keys) != 0 {
+		t.Errorf("GET /v1/keys = %v, want no plaintext keys", item.Keys)
+	}
+}
+
+func TestKey_ValidateHandler(t *testing.T) {
+	h := testRouter()
+	_, created := doRequest(t, h, http.MethodPost, "/", map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	})
+
+	tests := []struct {
+		name   string
+		userID string
+		key    string
+		query  string
+		// serviceKey is sent as X-Service-Key, testServiceKey when it isn't set
+		serviceKey   string
+		noServiceKey bool
+		want         int
+		service      string
+	}{
+		{
+			name:    "test_valid_key",
+			userID:  "tester",
+			key:     created.Keys[key.ServiceRetro],
+			want:    http.StatusOK,
+			service: key.ServiceRetro,
+		},
+		{
+			name:    "test_valid_key_for_service",
+			userID:  "tester",
+			key:     created.Keys[key.ServiceRetro],
+			query:   "?service=retro",
+			want:    http.StatusOK,
+			service: key.ServiceRetro,
+		},
+		{
+			name:   "test_key_for_other_service",
+			userID: "tester",
+			key:    created.Keys[key.ServiceTimer],
+			query:  "?service=billing",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "test_unknown_service",
+			userID: "tester",
+			key:    created.Keys[key.ServiceRetro],
+			query:  "?service=bob",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:       "test_unknown_service_unauthenticated",
+			userID:     "tester",
+			key:        created.Keys[key.ServiceRetro],
+			query:      "?service=bob",
+			serviceKey: "bob",
+			want:       http.StatusUnauthorized,
+		},
+		{
+			name:   "test_invalid_key",
+			userID: "tester",
+			key:    "bob",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "test_unknown_user",
+			userID: "alice",
+			key:    created.Keys[key.ServiceRetro],
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "test_missing_user",
+			key:  created.Keys[key.ServiceRetro],
+			want: http.StatusBadRequest,
+		},
+		{
+			name:         "test_missing_service_key",
+			userID:       "tester",
+			key:          created.Keys[key.ServiceRetro],
+			noServiceKey: true,
+			want:         http.StatusUnauthorized,
+		},
+		{
+			name:       "test_invalid_service_key",
+			userID:     "tester",
+			key:        created.Keys[key.ServiceRetro],
+			serviceKey: "bob",
+			want:       http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{
+				"X-User-ID":     tt.userID,
+				"X-Service-Key": testServiceKey,
+			}
+			if tt.serviceKey != "" {
+				headers["X-Service-Key"] = tt.serviceKey
+			}
+			if tt.noServiceKey {
+				delete(headers, "X-Service-Key")
+			}
+			code, item := doRequest(t, h, http.MethodGet, "/"+tt.key+"/validate"+tt.query, headers)
+			if code != tt.want {
+				t.Errorf("GET /v1/keys/{key}/validate = %v, want %v", code, tt.want)
+			}
+			if item.Service != tt.service {
+				t.Errorf("GET /v1/keys/{key}/validate service = %v, want %v", item.Service, tt.service)
+			}
+		})
+	}
+}
+
+func TestKey_RevokeHandler(t *testing.T) {
+	h := testRouter()
+	headers := map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	}
+	_, created := doRequest(t, h, http.MethodPost, "/", headers)
+
+	validate := func(checkKey string) (int, key.ResponseItem) {
+		return doRequest(t, h, http.MethodGet, "/"+checkKey+"/validate", headers)
+	}
+
+	if code, _ := doRequest(t, h, http.MethodDelete, "/services/bob", headers); code != http.StatusBadRequest {
+		t.Errorf("DELETE /v1/keys/services/bob = %v, want %v", code, http.StatusBadRequest)
+	}
+
+	if code, _ := doRequest(t, h, http.MethodDelete, "/services/timer", headers); code != http.StatusOK {
+		t.Errorf("DELETE /v1/keys/services/timer = %v, want %v", code, http.StatusOK)
+	}
+	if code, item := validate(created.Keys[key.ServiceTimer]); code != http.StatusUnauthorized || item.Status != key.KeysRevoked {
+		t.Errorf("revoked timer key = %v %v, want %v %v", code, item.Status, http.StatusUnauthorized, key.KeysRevoked)
+	}
+	if code, _ := validate(created.Keys[key.ServiceRetro]); code != http.StatusOK {
+		t.Errorf("retro key = %v, want %v", code, http.StatusOK)
+	}
+
+	if code, _ := doRequest(t, h, http.MethodDelete, "/", headers); code != http.StatusOK {
+		t.Errorf("DELETE /v1/keys = %v, want %v", code, http.StatusOK)
+	}
+	if code, _ := validate(created.Keys[key.ServiceRetro]); code != http.StatusUnauthorized {
+		t.Errorf("revoked retro key = %v, want %v", code, http.StatusUnauthorized)
+	}
+
+	if code, _ := doRequest(t, h, http.MethodDelete, "/", map[string]string{
+		"X-User-ID":     "alice",
+		"X-Service-Key": testServiceKey,
+	}); code != http.StatusNotFound {
+		t.Errorf("DELETE /v1/keys unknown user = %v, want %v", code, http.StatusNotFound)
+	}
+}
+
+func TestKey_RotateHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		want  int
+	}{
+		{
+			name:  "test_previous_key_in_grace",
+			grace: time.Minute,
+			want:  http.StatusOK,
+		},
+		{
+			name: "test_previous_key_without_grace",
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Local.RotationGrace = tt.grace
+			cfg.Local.Authorization.Rotate = []string{key.ServiceUser}
+			h := key.NewKey(cfg, key.NewMemory()).Routes()
+
+			headers := map[string]string{
+				"X-User-ID":     "tester",
+				"X-Service-Key": testServiceKey,
+			}
+			if code, _ := doRequest(t, h, http.MethodPost, "/rotate", headers); code != http.StatusNotFound {
+				t.Errorf("POST /v1/keys/rotate = %v, want %v", code, http.StatusNotFound)
+			}
+
+			_, created := doRequest(t, h, http.MethodPost, "/", headers)
+			code, rotated := doRequest(t, h, http.MethodPost, "/rotate", headers)
+			if code != http.StatusOK {
+				t.Fatalf("POST /v1/keys/rotate = %v, want %v", code, http.StatusOK)
+			}
+
+			validate := func(checkKey string) int {
+				code, _ := doRequest(t, h, http.MethodGet, "/"+checkKey+"/validate", headers)
+				return code
+			}
+			if code := validate(rotated.Keys[key.ServiceUser]); code != http.StatusOK {
+				t.Errorf("rotated key = %v, want %v", code, http.StatusOK)
+			}
+			if code := validate(created.Keys[key.ServiceUser]); code != tt.want {
+				t.Errorf("previous key = %v, want %v", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey_ValidateHandler_CallerNotAllowed(t *testing.T) {
+	cfg := testConfig()
+	cfg.Local.Services = withKey(cfg.Local.Services, key.ServiceTimer, "timerKey")
+	h := key.NewKey(cfg, key.NewMemory()).Routes()
+
+	_, created := doRequest(t, h, http.MethodPost, "/", map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": testServiceKey,
+	})
+
+	// AUTH_VALIDATE only lists user
+	code, _ := doRequest(t, h, http.MethodGet, "/"+created.Keys[key.ServiceRetro]+"/validate", map[string]string{
+		"X-User-ID":     "tester",
+		"X-Service-Key": "timerKey",
+	})
+	if code != http.StatusForbidden {
+		t.Errorf("GET /v1/keys/{key}/validate = %v, want %v", code, http.StatusForbidden)
+	}
+}
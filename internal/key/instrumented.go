@@ -0,0 +1,85 @@
+package key
+
+import (
+	"context"
+	"time"
+
+	"github.com/retro-board/key-service/internal/metrics"
+	"github.com/retro-board/key-service/internal/tracing"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// validation reasons that don't have an Error of their own
+const (
+	reasonMatched     = "matched"
+	reasonDevelopment = "development"
+	reasonUnknownUser = "unknown_user"
+	reasonNoMatch     = "no_match"
+)
+
+// instrumentedStore times and traces every store operation
+type instrumentedStore struct {
+	store KeyStore
+}
+
+func instrument(store KeyStore) KeyStore {
+	return &instrumentedStore{
+		store: store,
+	}
+}
+
+// observe starts the span for the operation, the returned func ends it and records the timing
+func (i *instrumentedStore) observe(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "store."+operation, trace.WithSpanKind(trace.SpanKindClient))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelCodes.Error, err.Error())
+		}
+		span.End()
+		metrics.ObserveStore(operation, start, err)
+	}
+}
+
+func (i *instrumentedStore) Get(ctx context.Context, userID string) (data *DataSet, err error) {
+	ctx, done := i.observe(ctx, "get")
+	defer func() { done(err) }()
+	return i.store.Get(ctx, userID)
+}
+
+func (i *instrumentedStore) Upsert(ctx context.Context, data DataSet) (err error) {
+	ctx, done := i.observe(ctx, "upsert")
+	defer func() { done(err) }()
+	return i.store.Upsert(ctx, data)
+}
+
+func (i *instrumentedStore) Delete(ctx context.Context, userID string) (err error) {
+	ctx, done := i.observe(ctx, "delete")
+	defer func() { done(err) }()
+	return i.store.Delete(ctx, userID)
+}
+
+func (i *instrumentedStore) Revoke(ctx context.Context, userID string, services []string) (err error) {
+	ctx, done := i.observe(ctx, "revoke")
+	defer func() { done(err) }()
+	return i.store.Revoke(ctx, userID, services)
+}
+
+func (i *instrumentedStore) List(ctx context.Context) (data []DataSet, err error) {
+	ctx, done := i.observe(ctx, "list")
+	defer func() { done(err) }()
+	return i.store.List(ctx)
+}
+
+func (i *instrumentedStore) Ping(ctx context.Context) (err error) {
+	ctx, done := i.observe(ctx, "ping")
+	defer func() { done(err) }()
+	return i.store.Ping(ctx)
+}
+
+func (i *instrumentedStore) Close(ctx context.Context) error {
+	return i.store.Close(ctx)
+}
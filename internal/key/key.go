@@ -1,35 +1,51 @@
 package key
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"math/big"
 	"time"
 
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"github.com/retro-board/key-service/internal/audit"
 	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/metrics"
 )
 
 type Key struct {
 	Config *config.Config
+	Store  KeyStore
+	Audit  audit.Sink
 }
 
 type ServiceKey struct {
-	Key string
+	Service   string
+	Key       string
+	ExpiresAt time.Time
+	Revoked   bool
+	Previous  bool
+}
+
+func (s ServiceKey) Expired() bool {
+	return !time.Now().Before(s.ExpiresAt)
 }
 
 type UserKey struct {
 	ID      string
 	Created time.Time
 
-	UserService    ServiceKey
-	RetroService   ServiceKey
-	TimerService   ServiceKey
-	CompanyService ServiceKey
-	BillingService ServiceKey
+	Keys map[string]ServiceKey
 }
 
-func NewKey(config *config.Config) *Key {
+func NewKey(config *config.Config, store KeyStore) *Key {
 	return &Key{
 		Config: config,
+		Store:  store,
+		Audit:  audit.Nop{},
 	}
 }
 
@@ -49,42 +65,182 @@ func (k *Key) GenerateServiceKey(n int) (string, error) {
 }
 
 func (k *Key) GetKeys(n int) (*ResponseItem, error) {
-	userKey, err := k.GenerateServiceKey(n)
-	if err != nil {
-		return nil, err
+	keys := make(map[string]string)
+	for _, service := range k.Config.Local.Services.Names() {
+		serviceKey, err := k.GenerateServiceKey(n)
+		if err != nil {
+			return nil, err
+		}
+		keys[service] = serviceKey
 	}
-	retroKey, err := k.GenerateServiceKey(n)
-	if err != nil {
-		return nil, err
+
+	return &ResponseItem{
+		Status: "ok",
+		Keys:   keys,
+	}, nil
+}
+
+func (k *Key) ValidService(service string) bool {
+	_, ok := k.Config.Local.Services.Get(service)
+	return ok
+}
+
+// SecureCompare is how every secret gets compared, hashing first means the length isn't leaked either
+func SecureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// HashKey is what gets stored and compared, the plaintext key is only ever handed out on create
+func (k *Key) HashKey(key string) string {
+	h := hmac.New(sha256.New, []byte(k.Config.Local.KeyPepper))
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (k *Key) keyTTL(override time.Duration) time.Duration {
+	if override > 0 {
+		return override
+	}
+	if k.Config.Local.KeyTTL > 0 {
+		return k.Config.Local.KeyTTL
+	}
+
+	return DefaultKeyTTL
+}
+
+func (k *Key) HashedDataSet(userID string, keys *ResponseItem) DataSet {
+	now := time.Now()
+
+	data := DataSet{
+		UserID:    userID,
+		Generated: now.Unix(),
+		Keys:      make(KeySet, len(keys.Keys)),
+		Expiry:    make(ExpirySet, len(keys.Keys)),
+	}
+	for service, plainKey := range keys.Keys {
+		registered, _ := k.Config.Local.Services.Get(service)
+		expiresAt := now.Add(k.keyTTL(registered.KeyTTL))
+
+		data.Keys[service] = k.HashKey(plainKey)
+		data.Expiry[service] = expiresAt.Unix()
+		if expiresAt.After(data.ExpiresAt) {
+			data.ExpiresAt = expiresAt
+		}
+	}
+
+	return data
+}
+
+// previousGeneration keeps the keys that still validate, each for no longer than it would have without the rotation,
+// revoked and expired keys don't get a grace period, the new keys replace them
+func previousGeneration(existing DataSet, validUntil time.Time) *Generation {
+	g := &Generation{
+		Keys:       make(KeySet),
+		ValidUntil: validUntil,
+		Expiry:     make(ExpirySet),
 	}
-	timerKey, err := k.GenerateServiceKey(n)
+	for _, serviceKey := range existing.ServiceKeys() {
+		if serviceKey.Previous || serviceKey.Revoked || serviceKey.Expired() {
+			continue
+		}
+
+		g.Keys[serviceKey.Service] = serviceKey.Key
+		if serviceKey.ExpiresAt.Before(validUntil) {
+			g.Expiry[serviceKey.Service] = serviceKey.ExpiresAt.Unix()
+		}
+	}
+
+	return g
+}
+
+// MatchKey finds which of the stored keys the check key is, nil if it is none of them,
+// when a service is given only the key issued for that service will match
+func (k *Key) MatchKey(data *DataSet, checkKey, service string) *ServiceKey {
+	var matched *ServiceKey
+
+	hashedKey := k.HashKey(checkKey)
+	serviceKeys := data.ServiceKeys()
+	for i := range serviceKeys {
+		// compare against every key so the time taken doesn't say which one matched
+		if SecureCompare(hashedKey, serviceKeys[i].Key) && (service == "" || service == serviceKeys[i].Service) {
+			matched = &serviceKeys[i]
+		}
+	}
+
+	return matched
+}
+
+// CreateKeys issues a fresh set of keys for the user, replacing whatever they had before
+func (k *Key) CreateKeys(ctx context.Context, userID string) (*ResponseItem, *Error) {
+	keys, err := k.GetKeys(25)
 	if err != nil {
-		return nil, err
+		bugLog.Info(err)
+		return nil, internalError("internal error, 1")
+	}
+
+	if err := k.Store.Upsert(ctx, k.HashedDataSet(userID, keys)); err != nil {
+		bugLog.Info(err)
+		return nil, internalError("internal error, 2")
 	}
-	companyKey, err := k.GenerateServiceKey(n)
+	metrics.KeyCreated(OperationCreate, len(keys.Keys))
+
+	return keys, nil
+}
+
+// RevokeKeys stops the given services keys validating straight away, rather than waiting for them to expire
+func (k *Key) RevokeKeys(ctx context.Context, userID string, services []string) *Error {
+	keys, err := k.Store.Get(ctx, userID)
 	if err != nil {
-		return nil, err
+		bugLog.Info(err)
+		return internalError("internal error, 5")
 	}
-	billingKey, err := k.GenerateServiceKey(n)
+	if keys == nil {
+		return ErrUserNotFound
+	}
+
+	if err := k.Store.Revoke(ctx, userID, services); err != nil {
+		bugLog.Info(err)
+		return internalError("internal error, 6")
+	}
+
+	return nil
+}
+
+// RotateKeys issues a new set of keys, the old set keeps validating until the grace period or its own expiry runs out
+func (k *Key) RotateKeys(ctx context.Context, userID string) (*ResponseItem, *Error) {
+	existing, err := k.Store.Get(ctx, userID)
 	if err != nil {
-		return nil, err
+		bugLog.Info(err)
+		return nil, internalError("internal error, 7")
 	}
-	permissionKey, err := k.GenerateServiceKey(n)
+	if existing == nil {
+		return nil, ErrUserNotFound
+	}
+
+	keys, err := k.GetKeys(25)
 	if err != nil {
-		return nil, err
+		bugLog.Info(err)
+		return nil, internalError("internal error, 8")
 	}
 
-	return &ResponseItem{
-		Status:      "ok",
-		User:        userKey,
-		Retro:       retroKey,
-		Timer:       timerKey,
-		Company:     companyKey,
-		Billing:     billingKey,
-		Permissions: permissionKey,
-	}, nil
-}
+	data := k.HashedDataSet(userID, keys)
+	if !existing.Expired() {
+		validUntil := time.Now().Add(k.Config.Local.RotationGrace)
+		if existing.expiresAt().Before(validUntil) {
+			validUntil = existing.expiresAt()
+		}
+
+		data.Previous = previousGeneration(*existing, validUntil)
+	}
+
+	if err := k.Store.Upsert(ctx, data); err != nil {
+		bugLog.Info(err)
+		return nil, internalError("internal error, 9")
+	}
+	metrics.KeyCreated(OperationRotate, len(keys.Keys))
 
-func (k *Key) ValidateServiceKey(key string) bool {
-	return k.Config.Local.OnePasswordKey == key
+	return keys, nil
 }
@@ -1,7 +1,9 @@
 package key_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/retro-board/key-service/internal/config"
 	"github.com/retro-board/key-service/internal/key"
@@ -22,7 +24,7 @@ func TestKey_GenerateServiceKey(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := key.NewKey(nil)
+			k := key.NewKey(nil, nil)
 			res, err := k.GenerateServiceKey(tt.length)
 			if err != nil {
 				t.Error(err)
@@ -39,32 +41,172 @@ func TestKey_ValidateServiceKey(t *testing.T) {
 	testKey := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 	tests := []struct {
-		name string
-		key  string
-		want bool
+		name   string
+		key    string
+		shared bool
+		want   bool
+		caller string
 	}{
 		{
-			name: "test_valid_key",
-			key:  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
-			want: true,
+			name:   "test_valid_key",
+			key:    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			shared: true,
+			want:   true,
+			caller: key.CallerShared,
 		},
 		{
-			name: "test_invalid_key",
-			key:  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1",
-			want: false,
+			name:   "test_invalid_key",
+			key:    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1",
+			shared: true,
+			want:   false,
+		},
+		{
+			name:   "test_shared_key_disabled",
+			key:    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			shared: false,
+			want:   false,
+		},
+		{
+			name:   "test_caller_key",
+			key:    "timerKey",
+			want:   true,
+			caller: key.ServiceTimer,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := key.NewKey(&config.Config{
+			cfg := &config.Config{
 				Local: config.Local{
-					OnePasswordKey: testKey,
+					OnePasswordKey:        testKey,
+					AllowSharedServiceKey: tt.shared,
 				},
-			})
-			if got := k.ValidateServiceKey(tt.key); got != tt.want {
+			}
+			cfg.Local.Services = config.Services{
+				{Name: key.ServiceUser, Key: "userKey"},
+				{Name: key.ServiceTimer, Key: "timerKey"},
+			}
+
+			k := key.NewKey(cfg, nil)
+			caller, got := k.ValidateServiceKey(tt.key)
+			if got != tt.want {
 				t.Errorf("Key.ValidateServiceKey() = %v, want %v", got, tt.want)
 			}
+			if caller != tt.caller {
+				t.Errorf("Key.ValidateServiceKey() caller = %v, want %v", caller, tt.caller)
+			}
+		})
+	}
+}
+
+func TestKey_ValidateServiceKey_Rotated(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Local.Services = config.Services{
+		{Name: key.ServiceUser, Key: "userKey"},
+	}
+	cfg.Local.Keyring = config.NewKeyring(config.ServiceKeys{
+		Services: map[string]string{key.ServiceUser: "userKey"},
+	}, time.Minute)
+	cfg.Local.Keyring.Rotate(config.ServiceKeys{
+		Services: map[string]string{key.ServiceUser: "rotatedKey"},
+	})
+	k := key.NewKey(cfg, nil)
+
+	for _, serviceKey := range []string{"userKey", "rotatedKey"} {
+		if caller, ok := k.ValidateServiceKey(serviceKey); !ok || caller != key.ServiceUser {
+			t.Errorf("Key.ValidateServiceKey(%s) = %v %v, want %v", serviceKey, caller, ok, key.ServiceUser)
+		}
+	}
+	if _, ok := k.ValidateServiceKey("bob"); ok {
+		t.Errorf("Key.ValidateServiceKey(bob) = true, want false")
+	}
+}
+
+func TestKey_Authorize(t *testing.T) {
+	cfg := &config.Config{
+		Local: config.Local{
+			OnePasswordKey:        "sharedKey",
+			AllowSharedServiceKey: true,
+			Authorization: config.Authorization{
+				Create:   []string{key.ServiceUser},
+				Validate: []string{key.ServiceUser, key.ServiceTimer},
+				Audit:    []string{key.ServiceUser},
+				Shared:   []string{key.OperationCreate, key.OperationValidate},
+			},
+		},
+	}
+	cfg.Local.Services = config.Services{
+		{Name: key.ServiceUser, Key: "userKey"},
+		{Name: key.ServiceTimer, Key: "timerKey"},
+	}
+	k := key.NewKey(cfg, nil)
+
+	tests := []struct {
+		name       string
+		serviceKey string
+		operation  string
+		want       *key.Error
+		caller     string
+	}{
+		{
+			name:       "test_user_create",
+			serviceKey: "userKey",
+			operation:  key.OperationCreate,
+			caller:     key.ServiceUser,
+		},
+		{
+			name:       "test_timer_create",
+			serviceKey: "timerKey",
+			operation:  key.OperationCreate,
+			want:       key.ErrCallerNotAllowed,
+		},
+		{
+			name:       "test_timer_validate",
+			serviceKey: "timerKey",
+			operation:  key.OperationValidate,
+			caller:     key.ServiceTimer,
+		},
+		{
+			name:       "test_shared_create",
+			serviceKey: "sharedKey",
+			operation:  key.OperationCreate,
+			caller:     key.CallerShared,
+		},
+		{
+			name:       "test_shared_audit",
+			serviceKey: "sharedKey",
+			operation:  key.OperationAudit,
+			want:       key.ErrCallerNotAllowed,
+		},
+		{
+			name:       "test_user_audit",
+			serviceKey: "userKey",
+			operation:  key.OperationAudit,
+			caller:     key.ServiceUser,
+		},
+		{
+			name:       "test_missing_key",
+			serviceKey: "",
+			operation:  key.OperationValidate,
+			want:       key.ErrMissingServiceKey,
+		},
+		{
+			name:       "test_invalid_key",
+			serviceKey: "bob",
+			operation:  key.OperationValidate,
+			want:       key.ErrInvalidServiceKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := k.Authorize(context.Background(), tt.serviceKey, tt.operation)
+			if err != tt.want {
+				t.Errorf("Key.Authorize() = %v, want %v", err, tt.want)
+			}
+			if caller := key.CallerFromContext(ctx); caller != tt.caller {
+				t.Errorf("Key.Authorize() caller = %v, want %v", caller, tt.caller)
+			}
 		})
 	}
 }
@@ -86,7 +228,11 @@ func TestKey_GetKeys(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := key.NewKey(nil)
+			k := key.NewKey(&config.Config{
+				Local: config.Local{
+					Services: config.DefaultServices(),
+				},
+			}, nil)
 			res, err := k.GetKeys(tt.keyLength)
 			if err != nil {
 				t.Error(err)
@@ -96,9 +242,223 @@ func TestKey_GetKeys(t *testing.T) {
 				t.Errorf("Key.GetKeys() = %v, want %v", res.Status, tt.want.Status)
 			}
 
-			if len(res.User) != tt.keyLength {
-				t.Errorf("Key.GetKeys() = %v, length = %v, want %v", res.Status, len(res.User), tt.keyLength)
+			if len(res.Keys) != len(config.DefaultServices()) {
+				t.Errorf("Key.GetKeys() = %v, keys = %v, want %v", res.Status, len(res.Keys), len(config.DefaultServices()))
+			}
+
+			if len(res.Keys[key.ServiceUser]) != tt.keyLength {
+				t.Errorf("Key.GetKeys() = %v, length = %v, want %v", res.Status, len(res.Keys[key.ServiceUser]), tt.keyLength)
+			}
+		})
+	}
+}
+
+func TestKey_HashedDataSet(t *testing.T) {
+	k := key.NewKey(&config.Config{
+		Local: config.Local{
+			KeyPepper: "pepper",
+			Services:  config.DefaultServices(),
+		},
+	}, nil)
+
+	keys, err := k.GetKeys(25)
+	if err != nil {
+		t.Error(err)
+	}
+
+	data := k.HashedDataSet("tester", keys)
+	userKey := keys.Keys[key.ServiceUser]
+	if data.Keys[key.ServiceUser] == userKey {
+		t.Errorf("Key.HashedDataSet() stored plaintext key %v", userKey)
+	}
+	if data.Keys[key.ServiceUser] != k.HashKey(userKey) {
+		t.Errorf("Key.HashedDataSet() = %v, want %v", data.Keys[key.ServiceUser], k.HashKey(userKey))
+	}
+
+	other := key.NewKey(&config.Config{
+		Local: config.Local{
+			KeyPepper: "salt",
+		},
+	}, nil)
+	if other.HashKey(userKey) == k.HashKey(userKey) {
+		t.Errorf("Key.HashKey() ignored the pepper")
+	}
+}
+
+func TestKey_MatchKey(t *testing.T) {
+	cfg := &config.Config{
+		Local: config.Local{
+			KeyPepper: "pepper",
+			KeyTTL:    time.Hour,
+		},
+	}
+	cfg.Local.Services = config.DefaultServices()
+	for i := range cfg.Local.Services {
+		if cfg.Local.Services[i].Name == key.ServiceTimer {
+			cfg.Local.Services[i].KeyTTL = time.Minute
+		}
+	}
+	k := key.NewKey(cfg, nil)
+
+	keys, err := k.GetKeys(25)
+	if err != nil {
+		t.Error(err)
+	}
+	data := k.HashedDataSet("tester", keys)
+	data.Expiry[key.ServiceBilling] = time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name     string
+		checkKey string
+		service  string
+		expired  bool
+		ttl      time.Duration
+		target   string
+	}{
+		{
+			name:     "test_default_ttl",
+			checkKey: keys.Keys[key.ServiceUser],
+			service:  key.ServiceUser,
+			ttl:      time.Hour,
+		},
+		{
+			name:     "test_service_ttl",
+			checkKey: keys.Keys[key.ServiceTimer],
+			service:  key.ServiceTimer,
+			ttl:      time.Minute,
+		},
+		{
+			name:     "test_expired_key",
+			checkKey: keys.Keys[key.ServiceBilling],
+			service:  key.ServiceBilling,
+			expired:  true,
+		},
+		{
+			name:     "test_unknown_key",
+			checkKey: "bob",
+		},
+		{
+			name:     "test_target_service",
+			checkKey: keys.Keys[key.ServiceRetro],
+			service:  key.ServiceRetro,
+			target:   key.ServiceRetro,
+			ttl:      time.Hour,
+		},
+		{
+			name:     "test_wrong_target_service",
+			checkKey: keys.Keys[key.ServiceTimer],
+			target:   key.ServiceBilling,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := k.MatchKey(&data, tt.checkKey, tt.target)
+			if tt.service == "" {
+				if got != nil {
+					t.Errorf("Key.MatchKey() = %v, want nil", got)
+				}
+				return
+			}
+
+			if got == nil || got.Service != tt.service {
+				t.Fatalf("Key.MatchKey() = %v, want %v", got, tt.service)
+			}
+			if got.Expired() != tt.expired {
+				t.Errorf("Key.MatchKey() expired = %v, want %v", got.Expired(), tt.expired)
+			}
+			if tt.ttl > 0 && time.Until(got.ExpiresAt) > tt.ttl {
+				t.Errorf("Key.MatchKey() expires = %v, want within %v", got.ExpiresAt, tt.ttl)
 			}
 		})
 	}
+
+	if !data.ExpiresAt.After(time.Now().Add(time.Minute * 59)) {
+		t.Errorf("Key.HashedDataSet() expires_at = %v, want the latest key expiry", data.ExpiresAt)
+	}
+}
+
+func TestSecureCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "test_equal",
+			a:    "tester",
+			b:    "tester",
+			want: true,
+		},
+		{
+			name: "test_different",
+			a:    "tester",
+			b:    "testes",
+			want: false,
+		},
+		{
+			name: "test_different_length",
+			a:    "tester",
+			b:    "tester1",
+			want: false,
+		},
+		{
+			name: "test_empty",
+			a:    "tester",
+			b:    "",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key.SecureCompare(tt.a, tt.b); got != tt.want {
+				t.Errorf("SecureCompare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey_RotateKeys_NeverExtends(t *testing.T) {
+	cfg := testConfig()
+	cfg.Local.RotationGrace = time.Minute * 5
+	store := key.NewMemory()
+	k := key.NewKey(cfg, store)
+	ctx := context.Background()
+
+	keys, err := k.GetKeys(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := k.HashedDataSet("tester", keys)
+	// timer expired a minute ago, retro expires before the grace period would end
+	data.Expiry[key.ServiceTimer] = time.Now().Add(-time.Minute).Unix()
+	retroExpiry := time.Now().Add(time.Second * 30).Unix()
+	data.Expiry[key.ServiceRetro] = retroExpiry
+	if err := store.Upsert(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, rotateErr := k.RotateKeys(ctx, "tester"); rotateErr != nil {
+		t.Fatalf("Key.RotateKeys() = %v", rotateErr)
+	}
+	rotated, err := store.Get(ctx, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matched := k.MatchKey(rotated, keys.Keys[key.ServiceTimer], ""); matched != nil && !matched.Expired() {
+		t.Errorf("expired timer key matched as %+v, want it to stay expired", matched)
+	}
+	matched := k.MatchKey(rotated, keys.Keys[key.ServiceRetro], "")
+	if matched == nil || !matched.Previous {
+		t.Fatalf("retro key = %+v, want it to match as the previous key", matched)
+	}
+	if matched.ExpiresAt.Unix() != retroExpiry {
+		t.Errorf("retro key expires %v, want %v", matched.ExpiresAt, time.Unix(retroExpiry, 0))
+	}
+	if matched := k.MatchKey(rotated, keys.Keys[key.ServiceUser], ""); matched == nil || matched.Expired() {
+		t.Errorf("user key = %+v, want it valid for the grace period", matched)
+	}
 }
@@ -0,0 +1,126 @@
+package key
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// keys are stored as <service>_service, which is what the fields were called before the registry
+const storedSuffix = "_service"
+
+func withSuffix[T any](m map[string]T) map[string]T {
+	stored := make(map[string]T, len(m))
+	for service, v := range m {
+		stored[service+storedSuffix] = v
+	}
+
+	return stored
+}
+
+func withoutSuffix[T any](stored map[string]T) map[string]T {
+	m := make(map[string]T, len(stored))
+	for name, v := range stored {
+		m[strings.TrimSuffix(name, storedSuffix)] = v
+	}
+
+	return m
+}
+
+// KeySet is the hashed key for each service
+type KeySet map[string]string
+
+func (k KeySet) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(withSuffix(k))
+}
+
+func (k *KeySet) UnmarshalBSON(data []byte) error {
+	var stored map[string]string
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*k = withoutSuffix(stored)
+
+	return nil
+}
+
+func (k KeySet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(withSuffix(k))
+}
+
+func (k *KeySet) UnmarshalJSON(data []byte) error {
+	var stored map[string]string
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*k = withoutSuffix(stored)
+
+	return nil
+}
+
+func (k KeySet) Services() []string {
+	services := make([]string, 0, len(k))
+	for service := range k {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	return services
+}
+
+func (k KeySet) serviceKeys() []ServiceKey {
+	serviceKeys := make([]ServiceKey, 0, len(k))
+	for _, service := range k.Services() {
+		serviceKeys = append(serviceKeys, ServiceKey{
+			Service: service,
+			Key:     k[service],
+		})
+	}
+
+	return serviceKeys
+}
+
+func (k KeySet) without(services []string) KeySet {
+	keys := make(KeySet, len(k))
+	for service, key := range k {
+		keys[service] = key
+	}
+	for _, service := range services {
+		delete(keys, service)
+	}
+
+	return keys
+}
+
+// ExpirySet is when each service key expires, in unix seconds
+type ExpirySet map[string]int64
+
+func (e ExpirySet) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(withSuffix(e))
+}
+
+func (e *ExpirySet) UnmarshalBSON(data []byte) error {
+	var stored map[string]int64
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*e = withoutSuffix(stored)
+
+	return nil
+}
+
+func (e ExpirySet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(withSuffix(e))
+}
+
+func (e *ExpirySet) UnmarshalJSON(data []byte) error {
+	var stored map[string]int64
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*e = withoutSuffix(stored)
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package key_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/retro-board/key-service/internal/key"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDataSet_UnmarshalBSON(t *testing.T) {
+	// a document as it was written before the registry
+	doc, err := bson.Marshal(bson.D{
+		{Key: "user_id", Value: "tester"},
+		{Key: "generated", Value: int64(1)},
+		{Key: "keys", Value: bson.D{
+			{Key: "user_service", Value: "userHash"},
+			{Key: "permissions_service", Value: "permissionsHash"},
+		}},
+		{Key: "expiry", Value: bson.D{
+			{Key: "user_service", Value: int64(2)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data key.DataSet
+	if err := bson.Unmarshal(doc, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Keys[key.ServiceUser] != "userHash" || data.Keys[key.ServicePermissions] != "permissionsHash" {
+		t.Errorf("DataSet.Keys = %v, want user and permissions", data.Keys)
+	}
+	if data.Expiry[key.ServiceUser] != 2 {
+		t.Errorf("DataSet.Expiry = %v, want %v", data.Expiry[key.ServiceUser], 2)
+	}
+
+	out, err := bson.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(out, &raw); err != nil {
+		t.Fatal(err)
+	}
+	keys, ok := raw["keys"].(bson.M)
+	if !ok || keys["user_service"] != "userHash" {
+		t.Errorf("DataSet marshal = %v, want keys.user_service", raw["keys"])
+	}
+}
+
+func TestResponseItem_MarshalJSON(t *testing.T) {
+	item := key.ResponseItem{
+		Status: "ok",
+		Keys: map[string]string{
+			key.ServiceUser:        "userKey",
+			key.ServicePermissions: "permissionsKey",
+			"audit":                "auditKey",
+		},
+	}
+
+	out, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"status":        "ok",
+		"user_service":  "userKey",
+		"permissions":   "permissionsKey",
+		"audit_service": "auditKey",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("ResponseItem.MarshalJSON() %s = %v, want %v", name, fields[name], value)
+		}
+	}
+
+	var back key.ResponseItem
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Keys) != len(item.Keys) || back.Keys[key.ServicePermissions] != "permissionsKey" {
+		t.Errorf("ResponseItem.UnmarshalJSON() = %v, want %v", back.Keys, item.Keys)
+	}
+}
@@ -0,0 +1,123 @@
+package key
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mrz1836/go-sanitize"
+)
+
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string]DataSet
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		data: make(map[string]DataSet),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, userID string) (*DataSet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dataSet, ok := m.data[sanitize.AlphaNumeric(userID, false)]
+	if !ok {
+		return nil, nil
+	}
+
+	dataSet = dataSet.clone()
+	return &dataSet, nil
+}
+
+func (m *Memory) Upsert(ctx context.Context, data DataSet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data.UserID = sanitize.AlphaNumeric(data.UserID, false)
+	data.Generated = time.Now().Unix()
+	m.data[data.UserID] = data.clone()
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, sanitize.AlphaNumeric(userID, false))
+
+	return nil
+}
+
+func (m *Memory) Revoke(ctx context.Context, userID string, services []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID = sanitize.AlphaNumeric(userID, false)
+	dataSet, ok := m.data[userID]
+	if !ok {
+		return nil
+	}
+
+	revoked := make([]string, 0, len(dataSet.Revoked)+len(services))
+	revoked = append(revoked, dataSet.Revoked...)
+	for _, service := range services {
+		if !dataSet.revoked(service) {
+			revoked = append(revoked, service)
+		}
+	}
+	dataSet.Revoked = revoked
+	m.data[userID] = dataSet
+
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context) ([]DataSet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dataSets := make([]DataSet, 0, len(m.data))
+	for _, dataSet := range m.data {
+		dataSets = append(dataSets, dataSet.clone())
+	}
+	sort.Slice(dataSets, func(i, j int) bool {
+		return dataSets[i].UserID < dataSets[j].UserID
+	})
+
+	return dataSets, nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
+
+// clone copies the maps so nothing outside the lock shares them with the store
+func (d DataSet) clone() DataSet {
+	c := d
+	c.Keys = d.Keys.without(nil)
+	c.Expiry = make(ExpirySet, len(d.Expiry))
+	for service, expiry := range d.Expiry {
+		c.Expiry[service] = expiry
+	}
+	c.Revoked = append([]string(nil), d.Revoked...)
+	if d.Previous != nil {
+		c.Previous = &Generation{
+			Keys:       d.Previous.Keys.without(nil),
+			ValidUntil: d.Previous.ValidUntil,
+			Expiry:     make(ExpirySet, len(d.Previous.Expiry)),
+		}
+		for service, expiry := range d.Previous.Expiry {
+			c.Previous.Expiry[service] = expiry
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,87 @@
+package key_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/retro-board/key-service/internal/key"
+)
+
+func TestMemory_Upsert(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		get    string
+		want   bool
+	}{
+		{
+			name:   "test_stored_user",
+			userID: "tester",
+			get:    "tester",
+			want:   true,
+		},
+		{
+			name:   "test_missing_user",
+			userID: "tester",
+			get:    "bob",
+			want:   false,
+		},
+		{
+			name:   "test_sanitized_user",
+			userID: "tester",
+			get:    "tes-ter",
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := key.NewMemory()
+
+			data := key.DataSet{
+				UserID: tt.userID,
+				Keys: key.KeySet{
+					key.ServiceUser: "userKey",
+				},
+			}
+			if err := m.Upsert(ctx, data); err != nil {
+				t.Error(err)
+			}
+
+			got, err := m.Get(ctx, tt.get)
+			if err != nil {
+				t.Error(err)
+			}
+			if (got != nil) != tt.want {
+				t.Errorf("Memory.Get() = %v, want %v", got, tt.want)
+			}
+			if got != nil && got.Keys[key.ServiceUser] != "userKey" {
+				t.Errorf("Memory.Get() = %v, want %v", got.Keys[key.ServiceUser], "userKey")
+			}
+		})
+	}
+}
+
+func TestMemory_Delete(t *testing.T) {
+	ctx := context.Background()
+	m := key.NewMemory()
+
+	for _, userID := range []string{"bob", "alice"} {
+		if err := m.Upsert(ctx, key.DataSet{UserID: userID}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := m.Delete(ctx, "bob"); err != nil {
+		t.Error(err)
+	}
+
+	got, err := m.List(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(got) != 1 || got[0].UserID != "alice" {
+		t.Errorf("Memory.List() = %v, want %v", got, "alice")
+	}
+}
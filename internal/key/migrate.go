@@ -0,0 +1,25 @@
+package key
+
+import (
+	"context"
+)
+
+// Migrator is a store with data written by older versions of the service to bring up to date
+type Migrator interface {
+	Migrate(ctx context.Context) (int64, error)
+}
+
+// Migrate brings the store's data up to date and says how many key sets it changed,
+// a store with nothing to migrate changes none
+func Migrate(ctx context.Context, store KeyStore) (int64, error) {
+	if i, ok := store.(*instrumentedStore); ok {
+		store = i.store
+	}
+
+	m, ok := store.(Migrator)
+	if !ok {
+		return 0, nil
+	}
+
+	return m.Migrate(ctx)
+}
@@ -0,0 +1,26 @@
+package key_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+)
+
+func TestMigrate_NothingToMigrate(t *testing.T) {
+	store, err := key.NewStore(context.Background(), &config.Config{
+		Local: config.Local{KeyStore: key.StoreMemory},
+	})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	migrated, err := key.Migrate(context.Background(), store)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if migrated != 0 {
+		t.Errorf("got: %v, want: %v", migrated, 0)
+	}
+}
@@ -4,74 +4,187 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/mrz1836/go-sanitize"
 
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/retro-board/key-service/internal/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// how long requests still using the old client get to finish once the credentials have rotated
+const reconnectDrain = time.Second * 30
+
 type Mongo struct {
 	Config *config.Config
-	CTX    context.Context
+
+	mu     sync.RWMutex
+	client *mongo.Client
+	stop   context.CancelFunc
 }
 
-func NewMongo(c *config.Config) *Mongo {
+func NewMongoWithClient(c *config.Config, client *mongo.Client) *Mongo {
 	return &Mongo{
 		Config: c,
-		CTX:    context.Background(),
+		client: client,
+		stop:   func() {},
 	}
 }
 
-type DataSet struct {
-	UserID    string `json:"user_id" bson:"user_id"`
-	Generated int64  `json:"generated" bson:"generated"`
-	Keys      struct {
-		UserService        string `json:"user_service" bson:"user_service"`
-		RetroService       string `json:"retro_service" bson:"retro_service"`
-		TimerService       string `json:"timer_service" bson:"timer_service"`
-		CompanyService     string `json:"company_service" bson:"company_service"`
-		BillingService     string `json:"billing_service" bson:"billing_service"`
-		PermissionsService string `json:"permissions_service" bson:"permissions_service"`
-	} `json:"keys" bson:"keys"`
+// NewMongo creates the pooled client that every request shares, it should be closed on shutdown,
+// with vault issued credentials it reconnects by itself whenever they rotate
+func NewMongo(ctx context.Context, c *config.Config) (*Mongo, error) {
+	client, err := mongo.Connect(ctx, ClientOptions(c.Mongo))
+	if err != nil {
+		return nil, err
+	}
+
+	return StartMongo(ctx, c, client)
 }
 
-func (m *Mongo) getConnection() (*mongo.Client, error) {
-	client, err := mongo.Connect(
-		m.CTX,
-		options.Client().ApplyURI(fmt.Sprintf(
-			"mongodb+srv://%s:%s@%s",
-			m.Config.Mongo.Username,
-			m.Config.Mongo.Password,
-			m.Config.Mongo.Host)),
-	)
-	if err != nil {
+// StartMongo takes over a connected client, if the indexes can't be made the client is disconnected
+// so nothing is left running
+func StartMongo(ctx context.Context, c *config.Config, client *mongo.Client) (*Mongo, error) {
+	m := NewMongoWithClient(c, client)
+	if err := m.ensureIndexes(ctx); err != nil {
+		if discErr := client.Disconnect(ctx); discErr != nil {
+			bugLog.Info(discErr)
+		}
 		return nil, err
 	}
 
-	return client, nil
+	if c.Mongo.DynamicCredentials() {
+		var renewCtx context.Context
+		renewCtx, m.stop = context.WithCancel(context.Background())
+		go c.RenewMongoCredentials(renewCtx, func(creds config.Mongo) {
+			if err := m.Reconnect(renewCtx, creds); err != nil {
+				bugLog.Info(err)
+			}
+		})
+	}
+
+	return m, nil
+}
+
+func (m *Mongo) Client() *mongo.Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.client
 }
 
-func (m *Mongo) Get(key string) (*DataSet, error) {
-	client, err := m.getConnection()
+// Reconnect swaps in a client using the new credentials, the old one is left to drain before it is disconnected
+func (m *Mongo) Reconnect(ctx context.Context, creds config.Mongo) error {
+	client, err := mongo.Connect(ctx, ClientOptions(creds))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		_ = client.Disconnect(ctx)
+		return err
+	}
+
+	m.mu.Lock()
+	old := m.client
+	m.client = client
+	m.mu.Unlock()
+
+	go func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), reconnectDrain)
+		defer cancel()
+		if err := old.Disconnect(drainCtx); err != nil {
 			bugLog.Info(err)
 		}
 	}()
 
+	return nil
+}
+
+// the code mongo fails with when an index exists with the same keys but different options
+const indexOptionsConflict = 85
+
+// ExpiryIndex has mongo delete key sets once they have been expired for the retention window,
+// until then they are still there to be reported as expired
+func ExpiryIndex(retention time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention / time.Second)),
+	}
+}
+
+// ensureIndexes lets mongo delete key sets itself once they have expired
+func (m *Mongo) ensureIndexes(ctx context.Context) error {
+	index := ExpiryIndex(m.Config.Local.KeyRetention)
+	_, err := m.collection().Indexes().CreateOne(ctx, index)
+
+	// an index made with another retention is changed in place rather than dropped and rebuilt
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflict {
+		return m.collection().Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: m.collection().Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: index.Keys},
+				{Key: "expireAfterSeconds", Value: *index.Options.ExpireAfterSeconds},
+			}},
+		}).Err()
+	}
+
+	return err
+}
+
+// Migrate gives key sets written before expires_at existed the expiry they were already getting,
+// so the ttl index can clear them out
+func (m *Mongo) Migrate(ctx context.Context) (int64, error) {
+	if err := m.ensureIndexes(ctx); err != nil {
+		return 0, err
+	}
+
+	ttl := int64(DefaultKeyTTL / time.Second)
+	res, err := m.collection().UpdateMany(ctx,
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"expires_at": bson.M{"$toDate": bson.M{"$multiply": bson.A{
+					bson.M{"$add": bson.A{"$generated", ttl}},
+					1000,
+				}}},
+			}}},
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+func ClientOptions(c config.Mongo) *options.ClientOptions {
+	return options.Client().
+		ApplyURI(fmt.Sprintf(
+			"mongodb+srv://%s:%s@%s",
+			c.Username,
+			c.Password,
+			c.Host)).
+		SetMaxPoolSize(c.MaxPoolSize).
+		SetMinPoolSize(c.MinPoolSize).
+		SetMaxConnIdleTime(c.MaxConnIdleTime).
+		SetConnectTimeout(c.ConnectTimeout).
+		SetServerSelectionTimeout(c.ServerSelectionTimeout).
+		SetRetryWrites(c.RetryWrites).
+		SetRetryReads(c.RetryReads)
+}
+
+func (m *Mongo) collection() *mongo.Collection {
+	return m.Client().Database("keys").Collection("keys")
+}
+
+func (m *Mongo) Get(ctx context.Context, userID string) (*DataSet, error) {
 	var dataSet DataSet
-	err = client.
-		Database("keys").
-		Collection("keys").
-		FindOne(m.CTX, map[string]string{"user_id": sanitize.AlphaNumeric(key, false)}).
+	err := m.collection().
+		FindOne(ctx, map[string]string{"user_id": sanitize.AlphaNumeric(userID, false)}).
 		Decode(&dataSet)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -80,38 +193,25 @@ func (m *Mongo) Get(key string) (*DataSet, error) {
 		return nil, err
 	}
 
-	dataTime := time.Unix(dataSet.Generated, 0).Unix()
-	minusTime := time.Now().Add(-time.Hour * 2).Unix()
-	plusTime := time.Now().Add(time.Hour * 2).Unix()
-	if dataTime >= minusTime && dataTime <= plusTime {
-		return &dataSet, nil
-	}
-
-	return nil, nil
+	return &dataSet, nil
 }
 
-func (m *Mongo) Create(data DataSet) error {
-	client, err := m.getConnection()
-	if err != nil {
-		return err
+func (m *Mongo) Upsert(ctx context.Context, data DataSet) error {
+	revoked := data.Revoked
+	if revoked == nil {
+		revoked = []string{}
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
-		}
-	}()
 
-	_, err = client.Database("keys").Collection("keys").UpdateOne(
-		m.CTX,
+	_, err := m.collection().UpdateOne(
+		ctx,
 		map[string]string{"user_id": sanitize.AlphaNumeric(data.UserID, false)},
 		bson.D{{Key: "$set", Value: bson.D{
 			{Key: "generated", Value: time.Now().Unix()},
-			{Key: "keys.user_service", Value: data.Keys.UserService},
-			{Key: "keys.retro_service", Value: data.Keys.RetroService},
-			{Key: "keys.timer_service", Value: data.Keys.TimerService},
-			{Key: "keys.company_service", Value: data.Keys.CompanyService},
-			{Key: "keys.billing_service", Value: data.Keys.BillingService},
-			{Key: "keys.permissions_service", Value: data.Keys.PermissionsService},
+			{Key: "keys", Value: data.Keys},
+			{Key: "expires_at", Value: data.ExpiresAt},
+			{Key: "expiry", Value: data.Expiry},
+			{Key: "revoked", Value: revoked},
+			{Key: "previous", Value: data.Previous},
 		}}},
 		options.Update().SetUpsert(true))
 	if err != nil {
@@ -120,3 +220,55 @@ func (m *Mongo) Create(data DataSet) error {
 
 	return nil
 }
+
+func (m *Mongo) Delete(ctx context.Context, userID string) error {
+	_, err := m.collection().DeleteOne(
+		ctx,
+		map[string]string{"user_id": sanitize.AlphaNumeric(userID, false)})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) Revoke(ctx context.Context, userID string, services []string) error {
+	_, err := m.collection().UpdateOne(
+		ctx,
+		map[string]string{"user_id": sanitize.AlphaNumeric(userID, false)},
+		bson.D{{Key: "$addToSet", Value: bson.D{
+			{Key: "revoked", Value: bson.D{{Key: "$each", Value: services}}},
+		}}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mongo) List(ctx context.Context) ([]DataSet, error) {
+	cursor, err := m.collection().Find(
+		ctx,
+		bson.D{},
+		options.Find().SetSort(bson.D{{Key: "user_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var dataSets []DataSet
+	if err := cursor.All(ctx, &dataSets); err != nil {
+		return nil, err
+	}
+
+	return dataSets, nil
+}
+
+// Ping checks the primary is reachable and the credentials still work
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.Client().Ping(ctx, readpref.Primary())
+}
+
+func (m *Mongo) Close(ctx context.Context) error {
+	m.stop()
+	return m.Client().Disconnect(ctx)
+}
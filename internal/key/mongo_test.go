@@ -0,0 +1,99 @@
+package key_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func mongoConfig(b *testing.B) *config.Config {
+	b.Helper()
+
+	if os.Getenv("MONGO_HOST") == "" {
+		b.Skip("MONGO_HOST not set")
+	}
+
+	cfg := &config.Config{}
+	if err := env.Parse(&cfg.Mongo); err != nil {
+		b.Fatalf("parse env: %v", err)
+	}
+
+	return cfg
+}
+
+// BenchmarkMongo_ConnectPerCall is how every Get used to behave, connecting and disconnecting each time
+func BenchmarkMongo_ConnectPerCall(b *testing.B) {
+	cfg := mongoConfig(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, err := mongo.Connect(ctx, key.ClientOptions(cfg.Mongo))
+		if err != nil {
+			b.Fatal(err)
+		}
+		m := key.NewMongoWithClient(cfg, client)
+		if _, err := m.Get(ctx, "benchmark"); err != nil {
+			b.Fatal(err)
+		}
+		if err := m.Close(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMongo_Pooled(b *testing.B) {
+	cfg := mongoConfig(b)
+	ctx := context.Background()
+
+	m, err := key.NewMongo(ctx, cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if err := m.Close(ctx); err != nil {
+			b.Error(err)
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Get(ctx, "benchmark"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStartMongo_IndexFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// nothing listens here, so making the indexes fails once server selection gives up
+	client, err := mongo.Connect(ctx, options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(time.Millisecond*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := key.StartMongo(ctx, &config.Config{}, client); err == nil {
+		t.Fatal("StartMongo() error = nil, want the index failure")
+	}
+	if err := client.Ping(ctx, nil); !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("Ping() error = %v, want the client disconnected", err)
+	}
+}
+
+func TestExpiryIndex(t *testing.T) {
+	index := key.ExpiryIndex(time.Hour * 24)
+	if got := *index.Options.ExpireAfterSeconds; got != 86400 {
+		t.Errorf("ExpiryIndex() expireAfterSeconds = %v, want 86400 so expired key sets are kept a day", got)
+	}
+}
@@ -0,0 +1,133 @@
+package key
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+)
+
+const (
+	StoreMongo  = "mongo"
+	StoreMemory = "memory"
+)
+
+// the services the key-service started with, the registry in config can add more
+const (
+	ServiceUser        = "user"
+	ServiceRetro       = "retro"
+	ServiceTimer       = "timer"
+	ServiceCompany     = "company"
+	ServiceBilling     = "billing"
+	ServicePermissions = "permissions"
+)
+
+const DefaultKeyTTL = time.Hour * 2
+
+type KeyStore interface {
+	Get(ctx context.Context, userID string) (*DataSet, error)
+	Upsert(ctx context.Context, data DataSet) error
+	Delete(ctx context.Context, userID string) error
+	Revoke(ctx context.Context, userID string, services []string) error
+	List(ctx context.Context) ([]DataSet, error)
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Generation is the key set replaced by a rotation, kept so it still validates for the grace period
+type Generation struct {
+	Keys       KeySet    `json:"keys" bson:"keys"`
+	ValidUntil time.Time `json:"valid_until" bson:"valid_until"`
+	// Expiry is kept for keys that were due to expire before ValidUntil, a rotation never extends a key
+	Expiry ExpirySet `json:"expiry,omitempty" bson:"expiry,omitempty"`
+}
+
+// expiresAt is when the previous key for the service stops validating
+func (g Generation) expiresAt(service string) time.Time {
+	if expiry, ok := g.Expiry[service]; ok && time.Unix(expiry, 0).Before(g.ValidUntil) {
+		return time.Unix(expiry, 0)
+	}
+
+	return g.ValidUntil
+}
+
+type DataSet struct {
+	UserID    string      `json:"user_id" bson:"user_id"`
+	Generated int64       `json:"generated" bson:"generated"`
+	ExpiresAt time.Time   `json:"expires_at" bson:"expires_at"`
+	Keys      KeySet      `json:"keys" bson:"keys"`
+	Expiry    ExpirySet   `json:"expiry" bson:"expiry"`
+	Revoked   []string    `json:"revoked,omitempty" bson:"revoked,omitempty"`
+	Previous  *Generation `json:"previous,omitempty" bson:"previous,omitempty"`
+}
+
+// expiresAt falls back to the old fixed window for documents written before expires_at existed
+func (d DataSet) expiresAt() time.Time {
+	if !d.ExpiresAt.IsZero() {
+		return d.ExpiresAt
+	}
+
+	return time.Unix(d.Generated, 0).Add(DefaultKeyTTL)
+}
+
+// Expires is when the key set stops validating
+func (d DataSet) Expires() time.Time {
+	return d.expiresAt()
+}
+
+func (d DataSet) Expired() bool {
+	return !time.Now().Before(d.expiresAt())
+}
+
+func (d DataSet) serviceExpiry(expiry int64) time.Time {
+	if expiry == 0 {
+		return d.expiresAt()
+	}
+
+	return time.Unix(expiry, 0)
+}
+
+func (d DataSet) revoked(service string) bool {
+	for _, r := range d.Revoked {
+		if r == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d DataSet) ServiceKeys() []ServiceKey {
+	serviceKeys := d.Keys.serviceKeys()
+	for i := range serviceKeys {
+		serviceKeys[i].ExpiresAt = d.serviceExpiry(d.Expiry[serviceKeys[i].Service])
+		serviceKeys[i].Revoked = d.revoked(serviceKeys[i].Service)
+	}
+
+	if d.Previous != nil {
+		for _, serviceKey := range d.Previous.Keys.serviceKeys() {
+			serviceKey.ExpiresAt = d.Previous.expiresAt(serviceKey.Service)
+			serviceKey.Revoked = d.revoked(serviceKey.Service)
+			serviceKey.Previous = true
+			serviceKeys = append(serviceKeys, serviceKey)
+		}
+	}
+
+	return serviceKeys
+}
+
+func NewStore(ctx context.Context, cfg *config.Config) (KeyStore, error) {
+	switch cfg.Local.KeyStore {
+	case StoreMemory:
+		return instrument(NewMemory()), nil
+	case StoreMongo, "":
+		m, err := NewMongo(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return instrument(m), nil
+	}
+
+	return nil, fmt.Errorf("unknown key store: %s", cfg.Local.KeyStore)
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "key_service"
+
+var (
+	KeysCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keys_created_total",
+		Help:      "Service keys handed out, by the operation that created them",
+	}, []string{"operation"})
+
+	Validations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validations_total",
+		Help:      "Key validations, by result and the reason for it",
+	}, []string{"result", "reason"})
+
+	ServiceKeyRotations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_key_rotations_total",
+		Help:      "Service keys swapped in by the refresher, by service",
+	}, []string{"service"})
+
+	ServiceKeyRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_key_refreshes_total",
+		Help:      "Reads of the service keys from vault, by result",
+	}, []string{"result"})
+
+	StoreOperations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Time taken by key store operations",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func KeyCreated(operation string, keys int) {
+	KeysCreated.WithLabelValues(operation).Add(float64(keys))
+}
+
+func ValidationSucceeded(reason string) {
+	Validations.WithLabelValues("valid", strings.ToLower(reason)).Inc()
+}
+
+func ValidationFailed(reason string) {
+	Validations.WithLabelValues("invalid", strings.ToLower(reason)).Inc()
+}
+
+func ObserveStore(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	StoreOperations.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
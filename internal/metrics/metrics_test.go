@@ -0,0 +1,55 @@
+package metrics_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/retro-board/key-service/internal/metrics"
+)
+
+func TestValidations(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(reason string)
+		reason string
+		result string
+		label  string
+	}{
+		{
+			name:   "test_valid",
+			record: metrics.ValidationSucceeded,
+			reason: "matched",
+			result: "valid",
+			label:  "matched",
+		},
+		{
+			name:   "test_invalid",
+			record: metrics.ValidationFailed,
+			reason: "USER_NOT_FOUND",
+			result: "invalid",
+			label:  "user_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.Validations.WithLabelValues(tt.result, tt.label))
+			tt.record(tt.reason)
+			after := testutil.ToFloat64(metrics.Validations.WithLabelValues(tt.result, tt.label))
+			if after-before != 1 {
+				t.Errorf("Validations{%s, %s} = %v, want %v", tt.result, tt.label, after, before+1)
+			}
+		})
+	}
+}
+
+func TestObserveStore(t *testing.T) {
+	metrics.ObserveStore("get", time.Now(), nil)
+	metrics.ObserveStore("get", time.Now(), errors.New("tester"))
+
+	if got := testutil.CollectAndCount(metrics.StoreOperations); got < 2 {
+		t.Errorf("StoreOperations series = %v, want at least 2", got)
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/retro-board/key-service/internal/health"
+	"github.com/retro-board/key-service/internal/key"
+	pb "github.com/retro-board/protos/generated/key/v1"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultHealthInterval = time.Second * 5
+
+func (s *Service) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+func (s *Service) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// newChecker is what readiness depends on, vault is only checked when there is a token to check
+func (s *Service) newChecker(store key.KeyStore) *health.Checker {
+	checker := health.NewChecker(s.Config.Local.HealthCacheTTL, s.Config.Local.HealthTimeout)
+	checker.Add("key_store", store.Ping)
+	if s.Config.Vault.CurrentToken() != "" {
+		checker.Add("vault", s.Config.VerifyVaultToken)
+	}
+
+	return checker
+}
+
+// readyHandler fails as soon as shutdown starts so the pod is taken out of the service before the servers stop
+func (s *Service) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.Ready() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(health.Report{
+			Status:    health.StatusShutdown,
+			CheckedAt: time.Now(),
+		})
+		return
+	}
+
+	s.health.ReadyHandler(w, r)
+}
+
+// watchHealth keeps the grpc health service in step with the readiness checks until ctx is done
+func (s *Service) watchHealth(ctx context.Context, hs *grpcHealth.Server) {
+	interval := s.Config.Local.HealthCacheTTL
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if s.Ready() && s.health.Check(ctx).Healthy() {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(pb.KeyService_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	bugMiddleware "github.com/bugfixes/go-bugfixes/middleware"
@@ -12,30 +16,142 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/keloran/go-healthcheck"
 	"github.com/keloran/go-probe"
+	"github.com/retro-board/key-service/internal/audit"
 	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/health"
 	"github.com/retro-board/key-service/internal/key"
+	"github.com/retro-board/key-service/internal/metrics"
+	"github.com/retro-board/key-service/internal/tracing"
 	pb "github.com/retro-board/protos/generated/key/v1"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
 	kitlog "github.com/go-kit/log"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/kit"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 )
 
 type Service struct {
 	Config *config.Config
+
+	// ready is read by the readiness probe, it goes back to 0 as soon as shutdown starts
+	ready  int32
+	health *health.Checker
 }
 
-func (s *Service) Start() error {
-	errChan := make(chan error)
-	go startGRPC(s.Config.GRPCPort, errChan, s.Config)
-	go startHTTP(s.Config.HTTPPort, errChan, s.Config.Development)
+// Start runs both servers until ctx is cancelled or either of them fails, then shuts everything down
+func (s *Service) Start(ctx context.Context) error {
+	shutdownTracing, err := tracing.Start(context.Background(), s.Config.Local.Tracing)
+	if err != nil {
+		return bugLog.Errorf("failed to start tracing: %v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			bugLog.Info(err)
+		}
+	}()
+
+	store, err := key.NewStore(context.Background(), s.Config)
+	if err != nil {
+		return bugLog.Errorf("failed to create key store: %v", err)
+	}
+
+	sink, err := key.NewAuditSink(context.Background(), s.Config, store)
+	if err != nil {
+		// nothing is serving yet, but the store has a client and maybe a renewer running
+		closeCtx, cancel := context.WithTimeout(context.Background(), s.Config.Local.ShutdownTimeout)
+		defer cancel()
+		if closeErr := store.Close(closeCtx); closeErr != nil {
+			bugLog.Info(closeErr)
+		}
+		return bugLog.Errorf("failed to create audit sink: %v", err)
+	}
+
+	s.health = s.newChecker(store)
+	healthServer := grpcHealth.NewServer()
+	gs := newGRPC(s.Config, store, sink, healthServer)
+	hs := s.newHTTP(store, sink)
+
+	errChan := make(chan error, 2)
+	go startGRPC(s.Config.GRPCPort, errChan, gs)
+	go startHTTP(errChan, hs)
+	s.setReady(true)
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go s.watchHealth(watchCtx, healthServer)
+	go s.Config.RefreshServiceKeys(watchCtx)
 
-	return <-errChan
+	select {
+	case <-ctx.Done():
+		bugLog.Local().Info("shutdown requested")
+	case err = <-errChan:
+	}
+
+	stopWatching()
+	healthServer.Shutdown()
+	s.shutdown(gs, hs, store, sink)
+	return err
 }
 
-func startGRPC(port int, errChan chan error, config *config.Config) {
+// shutdown stops taking traffic first, lets in-flight calls finish, and closes the store once nothing can use it
+func (s *Service) shutdown(gs *grpc.Server, hs *http.Server, store key.KeyStore, sink audit.Sink) {
+	s.setReady(false)
+	if s.Config.Local.ShutdownDelay > 0 {
+		bugLog.Local().Infof("waiting %s for readiness to propagate", s.Config.Local.ShutdownDelay)
+		time.Sleep(s.Config.Local.ShutdownDelay)
+	}
+
+	// one deadline covers the servers, the audit drain and the store, so the whole shutdown is bounded
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Local.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stopGRPC(ctx, gs)
+	}()
+	go func() {
+		defer wg.Done()
+		if err := hs.Shutdown(ctx); err != nil {
+			bugLog.Info(err)
+		}
+	}()
+	wg.Wait()
+
+	// the audit queue is written through the store's client, so it is drained first
+	if err := audit.Close(ctx, sink); err != nil {
+		bugLog.Info(err)
+	}
+	if err := store.Close(ctx); err != nil {
+		bugLog.Info(err)
+	}
+	bugLog.Local().Info("shutdown complete")
+}
+
+// stopGRPC waits for in-flight calls, anything still running at the deadline gets cut off
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		bugLog.Local().Info("grpc graceful stop timed out")
+		gs.Stop()
+	}
+}
+
+func newGRPC(config *config.Config, store key.KeyStore, sink audit.Sink, healthServer healthpb.HealthServer) *grpc.Server {
 	kOpts := []kit.Option{
 		kit.WithDecider(func(methodFullName string, err error) bool {
 			if err != nil {
@@ -47,46 +163,60 @@ func startGRPC(port int, errChan chan error, config *config.Config) {
 	}
 	opts := []grpc.ServerOption{
 		grpc_middleware.WithStreamServerChain(
+			otelgrpc.StreamServerInterceptor(),
 			kit.StreamServerInterceptor(kitlog.NewNopLogger(), kOpts...),
+			grpc_prometheus.StreamServerInterceptor,
 		),
 		grpc_middleware.WithUnaryServerChain(
+			otelgrpc.UnaryServerInterceptor(),
 			kit.UnaryServerInterceptor(kitlog.NewNopLogger(), kOpts...),
+			grpc_prometheus.UnaryServerInterceptor,
+			key.AuditInterceptor(config, sink),
 		),
 	}
 
+	gs := grpc.NewServer(opts...)
+	reflection.Register(gs)
+	pb.RegisterKeyServiceServer(gs, &key.Server{
+		Config: config,
+		Store:  store,
+	})
+	healthpb.RegisterHealthServer(gs, healthServer)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	grpc_prometheus.Register(gs)
+
+	return gs
+}
+
+func startGRPC(port int, errChan chan error, gs *grpc.Server) {
 	p := fmt.Sprintf(":%d", port)
 	bugLog.Local().Infof("Starting Key GRPC: %s", p)
 	lis, err := net.Listen("tcp", p)
 	if err != nil {
 		errChan <- bugLog.Errorf("failed to listen: %v", err)
+		return
 	}
-	gs := grpc.NewServer(opts...)
-	reflection.Register(gs)
-	pb.RegisterKeyServiceServer(gs, &key.Server{
-		Config: config,
-	})
 	if err := gs.Serve(lis); err != nil {
 		errChan <- bugLog.Errorf("failed to start grpc: %v", err)
 	}
 }
 
-func startHTTP(port int, errChan chan error, development bool) {
-	p := fmt.Sprintf(":%d", port)
-	bugLog.Local().Infof("Starting Key HTTP: %s", p)
+func (s *Service) newHTTP(store key.KeyStore, sink audit.Sink) *http.Server {
+	config := s.Config
 
 	allowedOrigins := []string{
 		"http://localhost:8080",
 		"https://retro-board.it",
 		"https://*.retro-board.it",
 	}
-	if development {
+	if config.Development {
 		allowedOrigins = append(allowedOrigins, "http://*")
 	}
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-User-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-User-Token", "X-User-ID", "X-Service-Key"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
@@ -95,11 +225,31 @@ func startHTTP(port int, errChan chan error, development bool) {
 	r := chi.NewRouter()
 	r.Use(middleware.Heartbeat("/ping"))
 	r.Use(middleware.RequestID)
+	r.Use(func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, "key-service.http")
+	})
 	r.Use(c.Handler)
 	r.Use(bugMiddleware.BugFixes)
 	r.Get("/health", healthcheck.HTTP)
 	r.Get("/probe", probe.HTTP)
-	if err := http.ListenAndServe(p, r); err != nil {
+	r.Get("/livez", health.LiveHandler)
+	r.Get("/readyz", s.readyHandler)
+	r.Handle("/metrics", metrics.Handler())
+	k := key.NewKey(config, store)
+	k.Audit = sink
+	r.Mount("/v1/keys", k.Routes())
+	r.Mount("/v1/audit", k.AuditRoutes())
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.HTTPPort),
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func startHTTP(errChan chan error, hs *http.Server) {
+	bugLog.Local().Infof("Starting Key HTTP: %s", hs.Addr)
+	if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		errChan <- bugLog.Errorf("port failed: %+v", err)
 	}
 }
@@ -0,0 +1,50 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/key"
+	"github.com/retro-board/key-service/internal/service"
+)
+
+func TestService_Shutdown(t *testing.T) {
+	s := &service.Service{
+		Config: &config.Config{
+			Local: config.Local{
+				KeyStore:        key.StoreMemory,
+				ShutdownTimeout: time.Second,
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Start(ctx)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !s.Ready() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !s.Ready() {
+		t.Fatal("Service.Ready() = false, want true once started")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Service.Start() = %v, want nil", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Service.Start() didn't return after shutdown")
+	}
+
+	if s.Ready() {
+		t.Errorf("Service.Ready() = true, want false after shutdown")
+	}
+}
@@ -0,0 +1,50 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/retro-board/key-service/internal/config"
+	"github.com/retro-board/key-service/internal/tracing"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Tracing
+		wantErr bool
+	}{
+		{
+			name: "test_default",
+			cfg:  config.Tracing{},
+		},
+		{
+			name: "test_none",
+			cfg:  config.Tracing{Exporter: tracing.ExporterNone},
+		},
+		{
+			name:    "test_unknown_exporter",
+			cfg:     config.Tracing{Exporter: "bob"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := tracing.Start(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("Shutdown() error = %v", err)
+			}
+			if _, span := tracing.Tracer().Start(context.Background(), "tester"); span.IsRecording() {
+				t.Errorf("Tracer() recording with no exporter")
+			}
+		})
+	}
+}